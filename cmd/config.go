@@ -0,0 +1,114 @@
+/*
+Copyright © 2022 Suraj Banakar surajrbanakar@gmail.com
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is the config file in the home directory
+const defaultConfigFile = ".kico.yaml"
+
+// cfgFile is the path of the config file (default is $HOME/.kico.yaml)
+var cfgFile string
+
+// applyConfig sets the flags of the command which are not set on the command line
+// to their values in the config file i.e., config < flags
+// The config file maps flag names to values e.g., `coredns-selector: k8s-app=coredns`
+// A list is set as comma separated values. A missing default config file is ignored
+func applyConfig(cmd *cobra.Command) error {
+	path := cfgFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, defaultConfigFile)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if cfgFile == "" && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("couldn't read the config file: %w", err)
+	}
+
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("couldn't parse the config file `%s`: %w", path, err)
+	}
+
+	for name, v := range config {
+		if name == "config" {
+			return fmt.Errorf("`config` can't be set in the config file `%s`", path)
+		}
+
+		f := cmd.Flags().Lookup(name)
+		if f == nil {
+			if !knownFlag(rootCmd, name) {
+				return fmt.Errorf("unknown flag `%s` in the config file `%s`", name, path)
+			}
+			// a flag of another command e.g., `since` for `kico doctor`
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+
+		value, err := configValue(v)
+		if err != nil {
+			return fmt.Errorf("invalid value of `%s` in the config file `%s`: %w", name, path, err)
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return fmt.Errorf("invalid value of `%s` in the config file `%s`: %w", name, path, err)
+		}
+	}
+	log.Printf("using the config file %s", path)
+	return nil
+}
+
+// configValue converts a value in the config file to the flag value
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		values := []string{}
+		for _, e := range v {
+			s, err := configValue(e)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	case map[string]interface{}:
+		return "", errors.New("expected a value or a list of values")
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// knownFlag checks whether the command or any of its subcommands has the flag
+func knownFlag(cmd *cobra.Command, name string) bool {
+	if cmd.Flags().Lookup(name) != nil {
+		return true
+	}
+	for _, c := range cmd.Commands() {
+		if knownFlag(c, name) {
+			return true
+		}
+	}
+	return false
+}
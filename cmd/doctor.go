@@ -0,0 +1,232 @@
+/*
+Copyright © 2022 Suraj Banakar surajrbanakar@gmail.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vadasambar/kico/pkg/runners/corednsrunner"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// doctorCmd checks if the environment is ready for kico
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks if the environment is ready for kico",
+	Long: `Checks if the environment is ready for kico i.e., kubeconfig loads, API server is reachable,
+CoreDNS pods are found, the CoreDNS 'log' plugin is enabled, the required RBAC permissions are present
+and the cluster domain is detected. For example:
+
+$ kico doctor
+[PASS] kubeconfig loads
+[PASS] API server is reachable: v1.25.4+k3s1
+[PASS] CoreDNS pods found: 2 pods in kube-system with k8s-app=kube-dns
+[FAIL] CoreDNS 'log' plugin is enabled: 'log' not found in the Corefile of kube-system/coredns ConfigMap
+...
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		corednsNamespace, err := cmd.Flags().GetString("coredns-namespace")
+		if err != nil {
+			log.Printf("err: %v error parsing `coredns-namespace` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultCoreDNSNamespace)
+			corednsNamespace = corednsrunner.DefaultCoreDNSNamespace
+		}
+
+		corednsSelector, err := cmd.Flags().GetString("coredns-selector")
+		if err != nil {
+			log.Printf("err: %v error parsing `coredns-selector` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultCoreDNSSelector)
+			corednsSelector = corednsrunner.DefaultCoreDNSSelector
+		}
+
+		pod, err := cmd.Flags().GetString("pod")
+		if err != nil {
+			log.Printf("err: %v error parsing `pod` flag", err)
+			log.Printf("defaulting to none")
+			pod = ""
+		}
+
+		if !doctor(corednsNamespace, corednsSelector, pod) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().String("coredns-namespace", corednsrunner.DefaultCoreDNSNamespace, "Namespace where the CoreDNS pods run")
+	doctorCmd.Flags().String("coredns-selector", corednsrunner.DefaultCoreDNSSelector, "Label selector of the CoreDNS pods")
+	doctorCmd.Flags().String("pod", "", "Detects the cluster domain from /etc/resolv.conf of the pod (<pod-name> in the namespace of the kubeconfig context or <namespace>/<pod-name>) like kico does for the target pod, which needs exec access to the pod (default reads the Corefile only)")
+}
+
+// doctor runs the checks one by one and prints the result of every check
+// It stops at the first check the other checks depend upon
+// The cluster domain is read from the resolv.conf of the pod if it's set
+// Returns true if all the checks pass
+func doctor(corednsNamespace, corednsSelector, pod string) bool {
+	passed := true
+	check := func(name string, detail string, err error) bool {
+		if err != nil {
+			passed = false
+			fmt.Printf("[FAIL] %s: %v\n", name, err)
+			return false
+		}
+		if detail != "" {
+			fmt.Printf("[PASS] %s: %s\n", name, detail)
+		} else {
+			fmt.Printf("[PASS] %s\n", name)
+		}
+		return true
+	}
+
+	var restConfig *rest.Config
+	var clientConfig clientcmd.ClientConfig
+	apiConfig, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err == nil {
+		clientConfig = clientcmd.NewDefaultClientConfig(*apiConfig, &clientcmd.ConfigOverrides{})
+		restConfig, err = clientConfig.ClientConfig()
+	}
+	if !check("kubeconfig loads", "", err) {
+		return passed
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if !check("K8s client is created", "", err) {
+		return passed
+	}
+
+	version, err := clientset.Discovery().ServerVersion()
+	if !check("API server is reachable", fmt.Sprintf("%v", version), err) {
+		return passed
+	}
+
+	ctx := context.Background()
+	detail := ""
+	podList, err := clientset.CoreV1().Pods(corednsNamespace).List(ctx, metav1.ListOptions{LabelSelector: corednsSelector})
+	if err == nil {
+		detail = fmt.Sprintf("%d pods in %s with %s", len(podList.Items), corednsNamespace, corednsSelector)
+		if len(podList.Items) == 0 {
+			err = &corednsrunner.ErrNoDNSPods{Namespace: corednsNamespace, Selector: corednsSelector}
+		}
+	}
+	check("CoreDNS pods found", detail, err)
+
+	corefile := ""
+	cm, err := clientset.CoreV1().ConfigMaps(corednsNamespace).Get(ctx, corednsrunner.CoreDNSConfigMap, metav1.GetOptions{})
+	if err == nil {
+		corefile = cm.Data["Corefile"]
+		if !corefileHasPlugin(corefile, "log") {
+			err = fmt.Errorf("'log' not found in the Corefile of %s/%s ConfigMap", corednsNamespace, corednsrunner.CoreDNSConfigMap)
+		}
+	}
+	check("CoreDNS 'log' plugin is enabled", "", err)
+
+	for _, a := range requiredAccess(corednsNamespace) {
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &a},
+		}, metav1.CreateOptions{})
+		if err == nil && !review.Status.Allowed {
+			err = fmt.Errorf("not allowed")
+		}
+		check(fmt.Sprintf("RBAC allows %s", describeAccess(a)), "", err)
+	}
+
+	// the same as kico detects it for the target pod (the resolv.conf and then the Corefile)
+	detail = ""
+	if pod != "" {
+		domain, p, err := podClusterDomain(ctx, restConfig, clientset, clientConfig, pod)
+		resolvConf := ""
+		if err == nil {
+			resolvConf = fmt.Sprintf("%s in %s", p.Name, p.Namespace)
+			detail = fmt.Sprintf("%s (from /etc/resolv.conf of the pod %s)", domain, resolvConf)
+		}
+		check("/etc/resolv.conf of the pod is read", resolvConf, err)
+	}
+	err = nil
+	if detail == "" {
+		if domain := corednsrunner.CorefileClusterDomain(corefile); domain != "" {
+			detail = fmt.Sprintf("%s (from the Corefile)", domain)
+		} else {
+			err = fmt.Errorf("couldn't find the `kubernetes` plugin zone in the Corefile, use `--cluster-domain`")
+		}
+	}
+	check("cluster domain is detected", detail, err)
+
+	return passed
+}
+
+// podClusterDomain reads the cluster domain from the resolv.conf of the pod
+// (`<pod-name>` in the namespace of the kubeconfig context or `<namespace>/<pod-name>`)
+func podClusterDomain(ctx context.Context, restConfig *rest.Config, clientset kubernetes.Interface, clientConfig clientcmd.ClientConfig, pod string) (string, *v1.Pod, error) {
+	namespace, name, found := strings.Cut(pod, "/")
+	if !found {
+		var err error
+		namespace, _, err = clientConfig.Namespace()
+		if err != nil {
+			return "", nil, err
+		}
+		name = pod
+	}
+
+	p, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+	domain, err := corednsrunner.PodClusterDomain(restConfig, clientset, p)
+	return domain, p, err
+}
+
+// requiredAccess returns the K8s API access kico needs
+func requiredAccess(corednsNamespace string) []authorizationv1.ResourceAttributes {
+	return []authorizationv1.ResourceAttributes{
+		{Verb: "get", Resource: "pods"},
+		{Verb: "list", Resource: "pods"},
+		{Verb: "list", Resource: "namespaces"},
+		{Verb: "list", Resource: "endpoints"},
+		{Verb: "list", Resource: "services"},
+		{Verb: "get", Resource: "replicasets", Group: "apps"},
+		{Verb: "list", Resource: "networkpolicies", Group: "networking.k8s.io"},
+		{Verb: "get", Resource: "pods", Subresource: "log", Namespace: corednsNamespace},
+		{Verb: "create", Resource: "pods", Subresource: "exec"},
+		{Verb: "get", Resource: "configmaps", Namespace: corednsNamespace},
+	}
+}
+
+func describeAccess(a authorizationv1.ResourceAttributes) string {
+	resource := a.Resource
+	if a.Subresource != "" {
+		resource = resource + "/" + a.Subresource
+	}
+	if a.Group != "" {
+		resource = resource + "." + a.Group
+	}
+	if a.Namespace != "" {
+		return fmt.Sprintf("%s %s in %s", a.Verb, resource, a.Namespace)
+	}
+	return fmt.Sprintf("%s %s", a.Verb, resource)
+}
+
+// corefileHasPlugin returns true if the plugin is used in any server block of the Corefile
+func corefileHasPlugin(corefile string, plugin string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(corefile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[0] == plugin {
+			return true
+		}
+	}
+	return false
+}
@@ -4,29 +4,116 @@ Copyright © 2022 Suraj Banakar surajrbanakar@gmail.com
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vadasambar/kico/pkg/runners/corednsrunner"
+	"golang.org/x/term"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 const defaultConcurrency = 4
 const defaultWaitDurationForLogs = "60s"
+const defaultOutput = corednsrunner.OutputText
+const defaultSinkTimeout = 5 * time.Second
+
+// options holds the parsed flags passed on to the runner
+type options struct {
+	suggestNetPol bool
+	concurrency   int
+	waitForLogs   time.Duration
+	timeoutPerPod time.Duration
+	output        string
+	watch         bool
+	onlyNew       bool
+	explain       bool
+	ip            string
+	qps           float32
+	burst         int
+	outputDir     string
+	ignoreLabels  []string
+	logFilter     *corednsrunner.LogFilter
+
+	corednsNamespace     string
+	corednsSelector      string
+	corednsFieldSelector string
+	kubeContexts         []string
+	anonymizer           *corednsrunner.Anonymizer
+	policyList           *corednsrunner.PolicyList
+	resolveStalePod      bool
+	smartDNSEgress       bool
+	excludeNs            []string
+	targetFQDN           string
+	fqdnAnnotation       string
+	workloadPodIPs       bool
+	includeIPBlocks      bool
+	// namespaceFromServiceAccount defaults the namespace to the one kico is running in
+	namespaceFromSA      bool
+	ipBlockPrefixLength  int
+	compact              bool
+	excludePods          []string
+	excludePodSelector   string
+	policyOnly           bool
+	outputNamespaces     bool
+	interactive          bool
+	dnsServiceName       string
+	insecureSkipTLS      bool
+	certificateAuthority string
+	detectHostNetwork    bool
+	sink                 *corednsrunner.Sink
+	maxPeers             int
+	maxCoreDNSPods       int
+	showCommands         bool
+	targetAllServices    bool
+	minimalPeerLabels    bool
+	labelKeyPriority     []string
+	mergeSubsetPeers     bool
+	watchMode            string
+	pollInterval         time.Duration
+	peerTTL              time.Duration
+	anyConnection        bool
+	loki                 *corednsrunner.Loki
+	logFiles             []string
+	failOnUnresolved     bool
+	// unresolvedFound is set if a pod had unresolved callers with failOnUnresolved
+	unresolvedFound   bool
+	largeResponse     bool
+	auditFile         string
+	dumpResources     string
+	dumpLogs          bool
+	waitForConnection time.Duration
+	singlePass        bool
+	policyGranularity string
+	color             bool
+	from              string
+	verifyPolicy      *networkingv1.NetworkPolicy
+	groupBy           string
+	resolveServices   bool
+	policyAPIVersion  string
+	// ctx is cancelled on the first interrupt (see interruptContext)
+	ctx context.Context
+}
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "kico <pod-name>",
+	Use:   "kico <pod-name|kind/name>...",
 	Short: "`kico` shows which pods are connecting to <pod-name>",
-	Long: `kico shows which pods are connecting to <pod-name>, prints the labels of such pods and suggests a NetworkPolicy to allow incoming connections to <pod-name>. For example:
+	Long: `kico shows which pods are connecting to <pod-name>, prints the labels of such pods and suggests a NetworkPolicy to allow incoming connections to <pod-name>.
+A workload can be used instead of a pod e.g., deployment/user-db or job/my-batch (a pod of the workload is analyzed). For example:
 
 $ kico user-db-b8dfb847c-wvkgf -nsock-shop --suggest-netpol
 INCOMING CONNECTIONS
 --------------------
-INFO[0000] pod: user-79dddf5cc9-bzvhd, ns: sock-shop via svc: user-db.sock-shop.svc.cluster.local. 
+pod: user-79dddf5cc9-bzvhd, ns: sock-shop via svc: user-db.sock-shop.svc.cluster.local. 
 
 creating a NetworkPolicy suggestion...
 
@@ -48,18 +135,60 @@ spec:
       name: user-db
 status: {}
 `,
+	// pod names are positional args, so they must not be
+	// treated as unknown subcommands (e.g., `doctor`)
+	Args: cobra.ArbitraryArgs,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
 		// fmt.Println("args", args)
-		if len(args) < 1 || strings.TrimSpace(args[0]) == "" {
-			log.Fatal("please provide a pod name")
+		ip, err := cmd.Flags().GetString("ip")
+		if err != nil {
+			log.Printf("err: %v error parsing `ip` flag", err)
+		}
+
+		podNames := []string{}
+		for _, a := range args {
+			if strings.TrimSpace(a) != "" {
+				podNames = append(podNames, strings.TrimSpace(a))
+			}
+		}
+
+		to, err := cmd.Flags().GetString("to")
+		if err != nil {
+			log.Printf("err: %v error parsing `to` flag", err)
+			to = ""
+		}
+		if to != "" {
+			podNames = append(podNames, to)
+		}
+
+		from, err := cmd.Flags().GetString("from")
+		if err != nil {
+			log.Printf("err: %v error parsing `from` flag", err)
+			from = ""
+		}
+		if from != "" && len(podNames) > 1 {
+			log.Fatal("`--from` requires exactly one target pod e.g., `kico --from <pod-name> --to <pod-name>`")
+		}
+		if len(podNames) == 0 && ip == "" {
+			log.Fatal("please provide a pod name or `--ip`")
+		}
+		if len(podNames) > 0 && ip != "" {
+			log.Fatal("please provide either a pod name or `--ip`, not both")
 		}
 		ns, err := cmd.Flags().GetString("namespace")
 		if err != nil {
 			log.Printf("err: %v namespace not provided, defaulting to `default`", err)
 		}
 
+		namespaceFromServiceAccount, err := cmd.Flags().GetBool("namespace-from-service-account")
+		if err != nil {
+			log.Printf("err: %v error parsing `namespace-from-service-account` flag", err)
+			log.Printf("defaulting to %v", false)
+			namespaceFromServiceAccount = false
+		}
+
 		suggestNetPol, err := cmd.Flags().GetBool("suggest-netpol")
 		if err != nil {
 			log.Printf("err: %v error parsing `suggest-netpol` flag", err)
@@ -88,12 +217,836 @@ status: {}
 			waitDuration = time.Second * 60
 		}
 
-		if err := run(args[0], ns, suggestNetPol, concurrency, waitDuration); err != nil {
-			log.Fatal(err)
+		timeoutPerPodLog, err := cmd.Flags().GetDuration("timeout-per-pod-log")
+		if err != nil {
+			log.Printf("err: %v error parsing `timeout-per-pod-log` flag", err)
+			log.Printf("defaulting to --wait-for-logs")
+			timeoutPerPodLog = 0
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			log.Printf("err: %v error parsing `output` flag", err)
+			log.Printf("defaulting to %s", defaultOutput)
+			output = defaultOutput
+		}
+		if !corednsrunner.TextOutput(output) && output != corednsrunner.OutputJSON && output != corednsrunner.OutputWideJSON && output != corednsrunner.OutputYAML && output != corednsrunner.OutputMarkdown {
+			log.Fatalf("unsupported output format `%s` (supported: %s, %s, %s, %s, %s, %s, %s)", output, corednsrunner.OutputText, corednsrunner.OutputWide, corednsrunner.OutputTable, corednsrunner.OutputJSON, corednsrunner.OutputWideJSON, corednsrunner.OutputYAML, corednsrunner.OutputMarkdown)
+		}
+
+		watch, err := cmd.Flags().GetBool("watch")
+		if err != nil {
+			log.Printf("err: %v error parsing `watch` flag", err)
+			log.Printf("defaulting to %v", false)
+			watch = false
+		}
+
+		onlyNew, err := cmd.Flags().GetBool("only-new")
+		if err != nil {
+			log.Printf("err: %v error parsing `only-new` flag", err)
+			log.Printf("defaulting to %v", false)
+			onlyNew = false
+		}
+		if onlyNew && !watch {
+			log.Fatal("`--only-new` can only be used with `--watch`")
+		}
+
+		watchMode, err := cmd.Flags().GetString("watch-mode")
+		if err != nil {
+			log.Printf("err: %v error parsing `watch-mode` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.WatchModeStream)
+			watchMode = corednsrunner.WatchModeStream
+		}
+		if watchMode != corednsrunner.WatchModeStream && watchMode != corednsrunner.WatchModePoll {
+			log.Fatalf("unsupported watch mode `%s` (supported: %s, %s)", watchMode, corednsrunner.WatchModeStream, corednsrunner.WatchModePoll)
+		}
+
+		peerTTL, err := cmd.Flags().GetDuration("peer-ttl")
+		if err != nil {
+			log.Printf("err: %v error parsing `peer-ttl` flag", err)
+			log.Printf("defaulting to no expiry")
+			peerTTL = 0
+		}
+		if peerTTL != 0 && !watch {
+			log.Fatal("`--peer-ttl` can only be used with `--watch`")
+		}
+
+		pollInterval, err := cmd.Flags().GetDuration("poll-interval")
+		if err != nil {
+			log.Printf("err: %v error parsing `poll-interval` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultPollInterval)
+			pollInterval = corednsrunner.DefaultPollInterval
+		}
+		if pollInterval <= 0 {
+			log.Fatal("`--poll-interval` must be greater than 0")
+		}
+		if watch && output != corednsrunner.OutputText && output != corednsrunner.OutputWide {
+			log.Fatalf("`--watch` only supports `%s` and `%s` output", corednsrunner.OutputText, corednsrunner.OutputWide)
+		}
+		compact, err := cmd.Flags().GetBool("compact")
+		if err != nil {
+			log.Printf("err: %v error parsing `compact` flag", err)
+			log.Printf("defaulting to %v", false)
+			compact = false
+		}
+		if compact && (output != corednsrunner.OutputText || watch) {
+			log.Fatalf("`--compact` only supports `%s` output without `--watch`", corednsrunner.OutputText)
+		}
+
+		policyOnly, err := cmd.Flags().GetBool("policy-only")
+		if err != nil {
+			log.Printf("err: %v error parsing `policy-only` flag", err)
+			log.Printf("defaulting to %v", false)
+			policyOnly = false
+		}
+		if policyOnly && (output != corednsrunner.OutputText || watch || compact) {
+			log.Fatalf("`--policy-only` only supports `%s` output without `--watch` and `--compact`", corednsrunner.OutputText)
+		}
+		if policyOnly {
+			suggestNetPol = true
+		}
+
+		outputNamespaces, err := cmd.Flags().GetBool("output-namespaces")
+		if err != nil {
+			log.Printf("err: %v error parsing `output-namespaces` flag", err)
+			log.Printf("defaulting to %v", false)
+			outputNamespaces = false
+		}
+
+		interactive, err := cmd.Flags().GetBool("interactive")
+		if err != nil {
+			log.Printf("err: %v error parsing `interactive` flag", err)
+			log.Printf("defaulting to %v", false)
+			interactive = false
+		}
+		if interactive && watch {
+			log.Fatal("`--interactive` can't be used with `--watch`")
+		}
+		if interactive {
+			suggestNetPol = true
+		}
+
+		dumpResources, err := cmd.Flags().GetString("dump-resources")
+		if err != nil {
+			log.Printf("err: %v error parsing `dump-resources` flag", err)
+			log.Printf("defaulting to no dump")
+			dumpResources = ""
+		}
+		if dumpResources != "" && len(podNames) > 1 {
+			log.Fatal("`--dump-resources` supports only one pod")
+		}
+
+		dumpLogs, err := cmd.Flags().GetBool("dump-logs")
+		if err != nil {
+			log.Printf("err: %v error parsing `dump-logs` flag", err)
+			log.Printf("defaulting to %v", false)
+			dumpLogs = false
+		}
+		if dumpLogs && dumpResources == "" {
+			log.Fatal("`--dump-logs` can only be used with `--dump-resources`")
+		}
+
+		auditFile, err := cmd.Flags().GetString("audit-file")
+		if err != nil {
+			log.Printf("err: %v error parsing `audit-file` flag", err)
+			log.Printf("defaulting to no audit")
+			auditFile = ""
+		}
+		if auditFile != "" && watch {
+			log.Fatal("`--audit-file` can't be used with `--watch`")
+		}
+		if auditFile != "" {
+			suggestNetPol = true
+		}
+
+		policyGranularity, err := cmd.Flags().GetString("policy-granularity")
+		if err != nil {
+			log.Printf("err: %v error parsing `policy-granularity` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.PolicyGranularitySingle)
+			policyGranularity = corednsrunner.PolicyGranularitySingle
+		}
+		if policyGranularity != corednsrunner.PolicyGranularitySingle && policyGranularity != corednsrunner.PolicyGranularityWorkload {
+			log.Fatalf("unsupported policy granularity `%s` (supported: %s, %s)", policyGranularity, corednsrunner.PolicyGranularitySingle, corednsrunner.PolicyGranularityWorkload)
+		}
+		if policyGranularity == corednsrunner.PolicyGranularityWorkload && (interactive || auditFile != "") {
+			log.Fatalf("`--policy-granularity %s` can't be used with `--interactive` or `--audit-file`", corednsrunner.PolicyGranularityWorkload)
+		}
+
+		includeIPBlocks, err := cmd.Flags().GetBool("include-ipblocks")
+		if err != nil {
+			log.Printf("err: %v error parsing `include-ipblocks` flag", err)
+			log.Printf("defaulting to %v", false)
+			includeIPBlocks = false
+		}
+		if includeIPBlocks && (interactive || policyGranularity != corednsrunner.PolicyGranularitySingle) {
+			log.Fatalf("`--include-ipblocks` can't be used with `--interactive` or `--policy-granularity %s`", corednsrunner.PolicyGranularityWorkload)
+		}
+
+		ipBlockPrefixLength, err := cmd.Flags().GetInt("ipblock-prefix-length")
+		if err != nil {
+			log.Printf("err: %v error parsing `ipblock-prefix-length` flag", err)
+			log.Printf("defaulting to %v", 32)
+			ipBlockPrefixLength = 32
+		}
+		if ipBlockPrefixLength < 1 || ipBlockPrefixLength > 32 {
+			log.Fatalf("`--ipblock-prefix-length` must be between 1 and 32, got %d", ipBlockPrefixLength)
+		}
+
+		groupBy, err := cmd.Flags().GetString("group-by")
+		if err != nil {
+			log.Printf("err: %v error parsing `group-by` flag", err)
+			log.Printf("defaulting to not grouping")
+			groupBy = ""
+		}
+		if groupBy != "" && groupBy != corednsrunner.GroupByProtocol {
+			log.Fatalf("unsupported `--group-by` `%s` (supported: %s)", groupBy, corednsrunner.GroupByProtocol)
+		}
+		if groupBy != "" && (watch || output == corednsrunner.OutputMarkdown) {
+			log.Fatalf("`--group-by` can't be used with `--watch` or `--output %s`", corednsrunner.OutputMarkdown)
+		}
+
+		resolveServices, err := cmd.Flags().GetBool("resolve-services")
+		if err != nil {
+			log.Printf("err: %v error parsing `resolve-services` flag", err)
+			log.Printf("defaulting to %v", false)
+			resolveServices = false
+		}
+		if resolveServices && watch {
+			log.Fatal("`--resolve-services` can't be used with `--watch`")
+		}
+
+		policyAPIVersion, err := cmd.Flags().GetString("policy-api-version")
+		if err != nil {
+			log.Printf("err: %v error parsing `policy-api-version` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.PolicyAPIVersionV1)
+			policyAPIVersion = corednsrunner.PolicyAPIVersionV1
+		}
+		if policyAPIVersion != corednsrunner.PolicyAPIVersionV1 && policyAPIVersion != corednsrunner.PolicyAPIVersionExtensionsV1beta1 {
+			log.Fatalf("unsupported `--policy-api-version` `%s` (supported: %s, %s)", policyAPIVersion, corednsrunner.PolicyAPIVersionV1, corednsrunner.PolicyAPIVersionExtensionsV1beta1)
+		}
+		if policyAPIVersion == corednsrunner.PolicyAPIVersionExtensionsV1beta1 {
+			log.Printf("warning: NetworkPolicy apiVersion %s is deprecated and not served since K8s 1.16, use it only for older clusters", policyAPIVersion)
+		}
+
+		largeResponse, err := cmd.Flags().GetBool("large-response")
+		if err != nil {
+			log.Printf("err: %v error parsing `large-response` flag", err)
+			log.Printf("defaulting to %v", false)
+			largeResponse = false
+		}
+
+		anyConnection, err := cmd.Flags().GetBool("any")
+		if err != nil {
+			log.Printf("err: %v error parsing `any` flag", err)
+			log.Printf("defaulting to %v", false)
+			anyConnection = false
+		}
+		if from != "" && (output != corednsrunner.OutputText || watch || compact || policyOnly || interactive || anyConnection) {
+			log.Fatalf("`--from` only supports `%s` output without `--watch`, `--compact`, `--policy-only`, `--interactive` and `--any`", corednsrunner.OutputText)
+		}
+		verifyPolicyFile, err := cmd.Flags().GetString("verify-policy")
+		if err != nil {
+			log.Printf("err: %v error parsing `verify-policy` flag", err)
+			verifyPolicyFile = ""
+		}
+		var verifyPolicy *networkingv1.NetworkPolicy
+		if verifyPolicyFile != "" {
+			if len(podNames) > 1 {
+				log.Fatal("`--verify-policy` requires exactly one target pod")
+			}
+			if from != "" || output != corednsrunner.OutputText || watch || compact || policyOnly || interactive || anyConnection {
+				log.Fatalf("`--verify-policy` only supports `%s` output without `--from`, `--watch`, `--compact`, `--policy-only`, `--interactive` and `--any`", corednsrunner.OutputText)
+			}
+			verifyPolicy, err = corednsrunner.ReadNetworkPolicy(verifyPolicyFile)
+			if err != nil {
+				log.Fatalf("couldn't read `--verify-policy`: %v", err)
+			}
+		}
+		if anyConnection && (output != corednsrunner.OutputText || compact || policyOnly || interactive || onlyNew) {
+			log.Fatalf("`--any` only supports `%s` output without `--compact`, `--policy-only`, `--interactive` and `--only-new`", corednsrunner.OutputText)
+		}
+
+		if auditFile != "" && len(podNames) > 1 {
+			log.Fatal("`--audit-file` supports only one pod")
+		}
+		if watch && len(podNames) > 1 {
+			log.Fatal("`--watch` supports only one pod")
+		}
+
+		explain, err := cmd.Flags().GetBool("explain")
+		if err != nil {
+			log.Printf("err: %v error parsing `explain` flag", err)
+			log.Printf("defaulting to %v", false)
+			explain = false
+		}
+
+		maxCoreDNSPods, err := cmd.Flags().GetInt("max-coredns-pods")
+		if err != nil {
+			log.Printf("err: %v error parsing `max-coredns-pods` flag", err)
+			log.Printf("defaulting to all the CoreDNS pods")
+			maxCoreDNSPods = 0
+		}
+
+		maxPeers, err := cmd.Flags().GetInt("max-peers")
+		if err != nil {
+			log.Printf("err: %v error parsing `max-peers` flag", err)
+			log.Printf("defaulting to no limit")
+			maxPeers = 0
+		}
+
+		mergeSubsetPeers, err := cmd.Flags().GetBool("merge-subset-peers")
+		if err != nil {
+			log.Printf("err: %v error parsing `merge-subset-peers` flag", err)
+			log.Printf("defaulting to %v", false)
+			mergeSubsetPeers = false
+		}
+
+		qps, err := cmd.Flags().GetFloat32("qps")
+		if err != nil {
+			log.Printf("err: %v error parsing `qps` flag", err)
+			log.Printf("defaulting to client-go default")
+			qps = 0
+		}
+
+		burst, err := cmd.Flags().GetInt("burst")
+		if err != nil {
+			log.Printf("err: %v error parsing `burst` flag", err)
+			log.Printf("defaulting to client-go default")
+			burst = 0
+		}
+
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			log.Printf("err: %v error parsing `output-dir` flag", err)
+		}
+
+		ignoreLabels, err := cmd.Flags().GetStringSlice("ignore-labels")
+		if err != nil {
+			log.Printf("err: %v error parsing `ignore-labels` flag", err)
+			log.Printf("defaulting to %v", corednsrunner.DefaultIgnoredPodLabels)
+			ignoreLabels = corednsrunner.DefaultIgnoredPodLabels
+		}
+
+		logLevelMarker, err := cmd.Flags().GetString("log-level-marker")
+		if err != nil {
+			log.Printf("err: %v error parsing `log-level-marker` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultLogFilter.LogLevelMarker)
+			logLevelMarker = corednsrunner.DefaultLogFilter.LogLevelMarker
+		}
+
+		continuationPrefix, err := cmd.Flags().GetString("continuation-prefix")
+		if err != nil {
+			log.Printf("err: %v error parsing `continuation-prefix` flag", err)
+			log.Printf("defaulting to no continuation lines")
+			continuationPrefix = ""
+		}
+
+		requireNoError, err := cmd.Flags().GetBool("require-noerror")
+		if err != nil {
+			log.Printf("err: %v error parsing `require-noerror` flag", err)
+			log.Printf("defaulting to %v", corednsrunner.DefaultLogFilter.RequireNoError)
+			requireNoError = corednsrunner.DefaultLogFilter.RequireNoError
+		}
+
+		includePTR, err := cmd.Flags().GetBool("include-ptr")
+		if err != nil {
+			log.Printf("err: %v error parsing `include-ptr` flag", err)
+			log.Printf("defaulting to %v", false)
+			includePTR = false
+		}
+
+		strictFQDN, err := cmd.Flags().GetBool("strict-fqdn")
+		if err != nil {
+			log.Printf("err: %v error parsing `strict-fqdn` flag", err)
+			log.Printf("defaulting to %v", false)
+			strictFQDN = false
+		}
+
+		clusterDomain, err := cmd.Flags().GetString("cluster-domain")
+		if err != nil {
+			log.Printf("err: %v error parsing `cluster-domain` flag", err)
+			log.Printf("defaulting to detecting the cluster domain")
+			clusterDomain = ""
+		}
+		clusterDomain = strings.Trim(clusterDomain, ".")
+
+		extraTargetFQDNs, err := cmd.Flags().GetStringArray("extra-target-fqdn")
+		if err != nil {
+			log.Printf("err: %v error parsing `extra-target-fqdn` flag", err)
+			log.Printf("defaulting to no extra FQDNs")
+			extraTargetFQDNs = nil
+		}
+		for i, f := range extraTargetFQDNs {
+			// normalize FQDNs with or without the trailing dot to have the trailing dot
+			extraTargetFQDNs[i] = strings.TrimSuffix(f, ".") + "."
+		}
+
+		corednsNamespace, err := cmd.Flags().GetString("coredns-namespace")
+		if err != nil {
+			log.Printf("err: %v error parsing `coredns-namespace` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultCoreDNSNamespace)
+			corednsNamespace = corednsrunner.DefaultCoreDNSNamespace
+		}
+
+		corednsSelector, err := cmd.Flags().GetString("coredns-selector")
+		if err != nil {
+			log.Printf("err: %v error parsing `coredns-selector` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultCoreDNSSelector)
+			corednsSelector = corednsrunner.DefaultCoreDNSSelector
+		}
+
+		corednsFieldSelector, err := cmd.Flags().GetString("coredns-field-selector")
+		if err != nil {
+			log.Printf("err: %v error parsing `coredns-field-selector` flag", err)
+			log.Printf("defaulting to no field selector")
+			corednsFieldSelector = ""
+		}
+
+		logBackend, err := cmd.Flags().GetString("log-backend")
+		if err != nil {
+			log.Printf("err: %v error parsing `log-backend` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.LogBackendPods)
+			logBackend = corednsrunner.LogBackendPods
+		}
+		if logBackend != corednsrunner.LogBackendPods && logBackend != corednsrunner.LogBackendLoki && logBackend != corednsrunner.LogBackendFile {
+			log.Fatalf("unsupported log backend `%s` (supported: %s, %s, %s)", logBackend, corednsrunner.LogBackendPods, corednsrunner.LogBackendLoki, corednsrunner.LogBackendFile)
+		}
+
+		noColor, err := cmd.Flags().GetBool("no-color")
+		if err != nil {
+			log.Printf("err: %v error parsing `no-color` flag", err)
+			log.Printf("defaulting to %v", false)
+			noColor = false
+		}
+		// https://no-color.org
+		_, noColorEnv := os.LookupEnv("NO_COLOR")
+		color := !noColor && !noColorEnv && term.IsTerminal(int(os.Stdout.Fd()))
+
+		waitForConnection, err := cmd.Flags().GetDuration("wait-for-connection")
+		if err != nil {
+			log.Printf("err: %v error parsing `wait-for-connection` flag", err)
+			log.Printf("defaulting to not waiting")
+			waitForConnection = 0
+		}
+
+		singlePass, err := cmd.Flags().GetBool("single-pass")
+		if err != nil {
+			log.Printf("err: %v error parsing `single-pass` flag", err)
+			log.Printf("defaulting to %v", false)
+			singlePass = false
+		}
+		if singlePass && continuationPrefix != "" {
+			log.Fatal("`--continuation-prefix` can't be used with `--single-pass`")
+		}
+
+		failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+		if err != nil {
+			log.Printf("err: %v error parsing `fail-on-unresolved` flag", err)
+			log.Printf("defaulting to %v", false)
+			failOnUnresolved = false
+		}
+
+		logFiles, err := cmd.Flags().GetStringArray("log-file")
+		if err != nil {
+			log.Printf("err: %v error parsing `log-file` flag", err)
+			logFiles = nil
+		}
+		if len(logFiles) > 0 && logBackend != corednsrunner.LogBackendFile {
+			log.Fatalf("`--log-file` requires `--log-backend %s`", corednsrunner.LogBackendFile)
+		}
+		if logBackend == corednsrunner.LogBackendFile {
+			if len(logFiles) == 0 {
+				log.Fatalf("`--log-backend %s` requires `--log-file`", corednsrunner.LogBackendFile)
+			}
+			if singlePass || watch || waitForConnection > 0 {
+				log.Fatalf("`--single-pass`, `--watch` and `--wait-for-connection` only support `--log-backend %s`", corednsrunner.LogBackendPods)
+			}
+		}
+
+		var loki *corednsrunner.Loki
+		if logBackend == corednsrunner.LogBackendLoki {
+			if singlePass {
+				log.Fatalf("`--single-pass` only supports `--log-backend %s`", corednsrunner.LogBackendPods)
+			}
+			if watch {
+				log.Fatalf("`--watch` only supports `--log-backend %s`", corednsrunner.LogBackendPods)
+			}
+			if waitForConnection > 0 {
+				log.Fatalf("`--wait-for-connection` only supports `--log-backend %s`", corednsrunner.LogBackendPods)
+			}
+
+			lokiURL, err := cmd.Flags().GetString("loki-url")
+			if err != nil {
+				log.Fatalf("err: %v error parsing `loki-url` flag", err)
+			}
+			if lokiURL == "" {
+				log.Fatalf("`--log-backend %s` requires `--loki-url`", corednsrunner.LogBackendLoki)
+			}
+
+			lokiQuery, err := cmd.Flags().GetString("loki-query")
+			if err != nil {
+				log.Printf("err: %v error parsing `loki-query` flag", err)
+				lokiQuery = ""
+			}
+			if lokiQuery == "" {
+				lokiQuery = corednsrunner.DefaultLokiQuery(corednsNamespace)
+				log.Printf("using loki query %s", lokiQuery)
+			}
+
+			lokiSince, err := cmd.Flags().GetDuration("loki-since")
+			if err != nil {
+				log.Printf("err: %v error parsing `loki-since` flag", err)
+				log.Printf("defaulting to %s", corednsrunner.DefaultLokiSince)
+				lokiSince = corednsrunner.DefaultLokiSince
+			}
+
+			loki = corednsrunner.NewLoki(lokiURL, lokiQuery, lokiSince)
+		}
+
+		detectHostNetwork, err := cmd.Flags().GetBool("detect-host-network")
+		if err != nil {
+			log.Printf("err: %v error parsing `detect-host-network` flag", err)
+			log.Printf("defaulting to %v", false)
+			detectHostNetwork = false
+		}
+
+		insecureSkipTLS, err := cmd.Flags().GetBool("insecure-skip-tls-verify")
+		if err != nil {
+			log.Printf("err: %v error parsing `insecure-skip-tls-verify` flag", err)
+			log.Printf("defaulting to %v", false)
+			insecureSkipTLS = false
+		}
+
+		certificateAuthority, err := cmd.Flags().GetString("certificate-authority")
+		if err != nil {
+			log.Printf("err: %v error parsing `certificate-authority` flag", err)
+			log.Printf("defaulting to the certificate authority in the kubeconfig")
+			certificateAuthority = ""
+		}
+		if insecureSkipTLS && certificateAuthority != "" {
+			log.Fatal("`--insecure-skip-tls-verify` and `--certificate-authority` can't be used together")
+		}
+		if insecureSkipTLS {
+			log.Printf("warning: `--insecure-skip-tls-verify` is set, the API server certificate is not verified. This makes the connection insecure")
+		}
+
+		kubeContexts, err := cmd.Flags().GetStringSlice("context")
+		if err != nil {
+			log.Printf("err: %v error parsing `context` flag", err)
+			log.Printf("defaulting to the current context")
+			kubeContexts = nil
+		}
+		if watch && len(kubeContexts) > 1 {
+			log.Fatal("`--watch` supports only one context")
+		}
+		if auditFile != "" && len(kubeContexts) > 1 {
+			log.Fatal("`--audit-file` supports only one context")
+		}
+		if dumpResources != "" && len(kubeContexts) > 1 {
+			log.Fatal("`--dump-resources` supports only one context")
+		}
+
+		anonymize, err := cmd.Flags().GetBool("anonymize")
+		if err != nil {
+			log.Printf("err: %v error parsing `anonymize` flag", err)
+			log.Printf("defaulting to %v", false)
+			anonymize = false
+		}
+		if anonymize && dumpResources != "" {
+			log.Fatal("`--dump-resources` can't be used with `--anonymize` because the resources are dumped as they are")
+		}
+
+		targetAllServices, err := cmd.Flags().GetBool("target-all-services")
+		if err != nil {
+			log.Printf("err: %v error parsing `target-all-services` flag", err)
+			log.Printf("defaulting to %v", false)
+			targetAllServices = false
+		}
+
+		minimalPeerLabels, err := cmd.Flags().GetBool("include-label-selector-only")
+		if err != nil {
+			log.Printf("err: %v error parsing `include-label-selector-only` flag", err)
+			log.Printf("defaulting to %v", false)
+			minimalPeerLabels = false
+		}
+
+		labelKeyPriority, err := cmd.Flags().GetStringSlice("label-key-priority")
+		if err != nil {
+			log.Printf("err: %v error parsing `label-key-priority` flag", err)
+			log.Printf("defaulting to %v", corednsrunner.DefaultLabelKeyPriority)
+			labelKeyPriority = corednsrunner.DefaultLabelKeyPriority
+		}
+
+		showCommands, err := cmd.Flags().GetBool("show-commands")
+		if err != nil {
+			log.Printf("err: %v error parsing `show-commands` flag", err)
+			log.Printf("defaulting to %v", false)
+			showCommands = false
+		}
+		if anonymize && showCommands {
+			log.Fatal("`--show-commands` can't be used with `--anonymize` because the commands have the real names")
+		}
+
+		sinkURL, err := cmd.Flags().GetString("sink-url")
+		if err != nil {
+			log.Printf("err: %v error parsing `sink-url` flag", err)
+			log.Printf("defaulting to no sink")
+			sinkURL = ""
+		}
+
+		sinkTimeout, err := cmd.Flags().GetDuration("sink-timeout")
+		if err != nil {
+			log.Printf("err: %v error parsing `sink-timeout` flag", err)
+			log.Printf("defaulting to %s", defaultSinkTimeout)
+			sinkTimeout = defaultSinkTimeout
+		}
+
+		outputPolicyList, err := cmd.Flags().GetBool("output-policy-list")
+		if err != nil {
+			log.Printf("err: %v error parsing `output-policy-list` flag", err)
+			log.Printf("defaulting to %v", false)
+			outputPolicyList = false
+		}
+
+		var policyList *corednsrunner.PolicyList
+		if outputPolicyList {
+			policyList = &corednsrunner.PolicyList{}
+			suggestNetPol = true
+		}
+
+		resolveStalePod, err := cmd.Flags().GetBool("resolve-stale-pod")
+		if err != nil {
+			log.Printf("err: %v error parsing `resolve-stale-pod` flag", err)
+			log.Printf("defaulting to %v", false)
+			resolveStalePod = false
+		}
+
+		smartDNSEgress, err := cmd.Flags().GetBool("smart-dns-egress")
+		if err != nil {
+			log.Printf("err: %v error parsing `smart-dns-egress` flag", err)
+			log.Printf("defaulting to %v", false)
+			smartDNSEgress = false
+		}
+
+		dnsServiceName, err := cmd.Flags().GetString("dns-service-name")
+		if err != nil {
+			log.Printf("err: %v error parsing `dns-service-name` flag", err)
+			log.Printf("defaulting to %s", corednsrunner.DefaultDNSServiceName)
+			dnsServiceName = corednsrunner.DefaultDNSServiceName
+		}
+
+		excludeNs, err := cmd.Flags().GetStringSlice("exclude-namespaces")
+		if err != nil {
+			log.Printf("err: %v error parsing `exclude-namespaces` flag", err)
+			log.Printf("defaulting to no excluded namespaces")
+			excludeNs = nil
+		}
+
+		excludeSystem, err := cmd.Flags().GetBool("exclude-system")
+		if err != nil {
+			log.Printf("err: %v error parsing `exclude-system` flag", err)
+			log.Printf("defaulting to %v", false)
+			excludeSystem = false
+		}
+		if excludeSystem {
+			excludeNs = append(excludeNs, corednsrunner.SystemNamespaces...)
+		}
+
+		excludePods, err := cmd.Flags().GetStringSlice("exclude-pod")
+		if err != nil {
+			log.Printf("err: %v error parsing `exclude-pod` flag", err)
+			log.Printf("defaulting to no excluded pods")
+			excludePods = nil
+		}
+
+		excludePodSelector, err := cmd.Flags().GetString("exclude-pod-selector")
+		if err != nil {
+			log.Printf("err: %v error parsing `exclude-pod-selector` flag", err)
+			log.Printf("defaulting to no excluded pods")
+			excludePodSelector = ""
+		}
+
+		targetFQDN, err := cmd.Flags().GetString("target-fqdn")
+		if err != nil {
+			log.Printf("err: %v error parsing `target-fqdn` flag", err)
+			log.Printf("defaulting to the FQDNs of the services selecting the pod")
+			targetFQDN = ""
+		}
+		if targetFQDN != "" {
+			// normalize FQDNs with or without the trailing dot to have the trailing dot
+			targetFQDN = strings.TrimSuffix(targetFQDN, ".") + "."
+		}
+		if targetFQDN != "" && targetAllServices {
+			log.Fatal("`--target-all-services` can't be used with `--target-fqdn` which skips the service discovery")
+		}
+
+		fqdnAnnotation, err := cmd.Flags().GetString("fqdn-from-annotation")
+		if err != nil {
+			log.Printf("err: %v error parsing `fqdn-from-annotation` flag", err)
+			log.Printf("defaulting to the FQDNs of the services selecting the pod")
+			fqdnAnnotation = ""
+		}
+		if fqdnAnnotation != "" && targetFQDN != "" {
+			log.Fatal("`--fqdn-from-annotation` can't be used with `--target-fqdn`")
+		}
+
+		workloadPodIPs, err := cmd.Flags().GetBool("match-workload-pod-ips")
+		if err != nil {
+			log.Printf("err: %v error parsing `match-workload-pod-ips` flag", err)
+			log.Printf("defaulting to %v", false)
+			workloadPodIPs = false
+		}
+		if workloadPodIPs {
+			if ip != "" {
+				log.Fatal("`--match-workload-pod-ips` requires a workload e.g., deployment/user-db, not `--ip`")
+			}
+			for _, p := range podNames {
+				if !strings.Contains(p, "/") {
+					log.Fatalf("`--match-workload-pod-ips` requires a workload e.g., deployment/user-db, not the pod %s", p)
+				}
+			}
+		}
+
+		var anonymizer *corednsrunner.Anonymizer
+		if anonymize {
+			anonymizer, err = corednsrunner.NewAnonymizer()
+			if err != nil {
+				log.Fatal(err)
+			}
+			// so that the user can de-anonymize the output
+			// (the failures below print it explicitly since log.Fatal skips it)
+			defer anonymizer.PrintMapping(os.Stderr)
+		}
+
+		var sink *corednsrunner.Sink
+		if sinkURL != "" {
+			sink = corednsrunner.NewSink(sinkURL, sinkTimeout)
+			// so that the queued connections are sent before exiting
+			// (the failures below close it explicitly since log.Fatal skips it)
+			defer sink.Close()
+		}
+
+		o := &options{
+			suggestNetPol: suggestNetPol,
+			concurrency:   concurrency,
+			waitForLogs:   waitDuration,
+			timeoutPerPod: timeoutPerPodLog,
+			output:        output,
+			watch:         watch,
+			onlyNew:       onlyNew,
+			explain:       explain,
+			ip:            ip,
+			qps:           qps,
+			burst:         burst,
+			outputDir:     outputDir,
+			ignoreLabels:  ignoreLabels,
+			logFilter: &corednsrunner.LogFilter{
+				LogLevelMarker:     logLevelMarker,
+				RequireNoError:     requireNoError,
+				ContinuationPrefix: continuationPrefix,
+				ExtraFQDNs:         extraTargetFQDNs,
+				IncludePTR:         includePTR,
+				StrictFQDN:         strictFQDN,
+				ClusterDomain:      clusterDomain,
+			},
+			corednsNamespace:     corednsNamespace,
+			corednsSelector:      corednsSelector,
+			corednsFieldSelector: corednsFieldSelector,
+			kubeContexts:         kubeContexts,
+			anonymizer:           anonymizer,
+			policyList:           policyList,
+			resolveStalePod:      resolveStalePod,
+			smartDNSEgress:       smartDNSEgress,
+			excludeNs:            excludeNs,
+			compact:              compact,
+			excludePods:          excludePods,
+			excludePodSelector:   excludePodSelector,
+			policyOnly:           policyOnly,
+			outputNamespaces:     outputNamespaces,
+			interactive:          interactive,
+			dnsServiceName:       dnsServiceName,
+			insecureSkipTLS:      insecureSkipTLS,
+			certificateAuthority: certificateAuthority,
+			detectHostNetwork:    detectHostNetwork,
+			sink:                 sink,
+			maxPeers:             maxPeers,
+			maxCoreDNSPods:       maxCoreDNSPods,
+			showCommands:         showCommands,
+			targetAllServices:    targetAllServices,
+			minimalPeerLabels:    minimalPeerLabels,
+			labelKeyPriority:     labelKeyPriority,
+			mergeSubsetPeers:     mergeSubsetPeers,
+			watchMode:            watchMode,
+			pollInterval:         pollInterval,
+			peerTTL:              peerTTL,
+			anyConnection:        anyConnection,
+			loki:                 loki,
+			logFiles:             logFiles,
+			failOnUnresolved:     failOnUnresolved,
+			largeResponse:        largeResponse,
+			auditFile:            auditFile,
+			dumpResources:        dumpResources,
+			dumpLogs:             dumpLogs,
+			waitForConnection:    waitForConnection,
+			singlePass:           singlePass,
+			policyGranularity:    policyGranularity,
+			color:                color,
+			from:                 from,
+			verifyPolicy:         verifyPolicy,
+			groupBy:              groupBy,
+			resolveServices:      resolveServices,
+			policyAPIVersion:     policyAPIVersion,
+			targetFQDN:           targetFQDN,
+			fqdnAnnotation:       fqdnAnnotation,
+			workloadPodIPs:       workloadPodIPs,
+			includeIPBlocks:      includeIPBlocks,
+			namespaceFromSA:      namespaceFromServiceAccount,
+			ipBlockPrefixLength:  ipBlockPrefixLength,
+		}
+
+		if ip != "" {
+			// the pod is found using the IP
+			podNames = []string{""}
+		}
+
+		ctx, stop := interruptContext()
+		defer stop()
+		o.ctx = ctx
+
+		if err := run(podNames, ns, o); err != nil {
+			var noDNSPods *corednsrunner.ErrNoDNSPods
+			if errors.As(err, &noDNSPods) {
+				fatalf(anonymizer, sink, "%v\nif CoreDNS runs elsewhere in your cluster, use `--coredns-namespace` and `--coredns-selector` (and check `--coredns-field-selector`) to point kico to the CoreDNS pods", err)
+			}
+			fatalf(anonymizer, sink, "%v", err)
+		}
+
+		if policyList != nil {
+			if corednsrunner.TextOutput(output) {
+				fmt.Fprintln(os.Stderr, "")
+				fmt.Fprintln(os.Stderr, "SUGGESTED NetworkPolicies")
+				fmt.Fprintln(os.Stderr, "-------------------------")
+			}
+			if err := policyList.Write(os.Stdout, output); err != nil {
+				fatalf(anonymizer, sink, "%v", err)
+			}
+		}
+
+		if o.unresolvedFound {
+			fatalf(anonymizer, sink, "some callers couldn't be resolved to pods (`--fail-on-unresolved`)")
 		}
 	},
 }
 
+// fatalf sends the queued connections to the sink and prints the mapping of
+// the anonymized names (if any) before log.Fatalf which exits without running
+// the deferred calls
+func fatalf(anonymizer *corednsrunner.Anonymizer, sink *corednsrunner.Sink, format string, v ...interface{}) {
+	sink.Close()
+	anonymizer.PrintMapping(os.Stderr)
+	log.Fatalf(format, v...)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -108,50 +1061,277 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kico.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "Config file setting the defaults of the flags, overridden by the flags on the command line (default $HOME/.kico.yaml if it exists)")
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if err := applyConfig(cmd); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rootCmd.Flags().StringP("namespace", "n", "", "Namespace where the pod exists (default uses current namespace)")
+	rootCmd.Flags().Bool("namespace-from-service-account", false, "Without --namespace and a namespace in the kubeconfig context, uses the namespace kico is running in (read from its service account) instead of default e.g., for running kico as a Job (default false)")
 	rootCmd.Flags().BoolP("suggest-netpol", "s", false, "Suggests a NetworkPolicy if the flag is set (default false)")
-	rootCmd.Flags().IntP("concurrency", "c", defaultConcurrency, "Sets concurrency for processing logs")
-	rootCmd.Flags().StringP("wait-for-logs", "w", defaultWaitDurationForLogs, "Waits for relevant logs to appear")
+	rootCmd.Flags().IntP("concurrency", "c", defaultConcurrency, "Sets concurrency for processing logs and listing the endpoints per namespace")
+	rootCmd.Flags().Bool("resolve-services", false, "Prints every service each caller used to reach the pod after the connections e.g., pod X used services [a, b] (default false)")
+	rootCmd.Flags().String("group-by", "", "Splits the connections by the transport of their queries with the number of queries per transport. One of: protocol (a section per protocol in the text output, a PROTOCOL column in the table output and transports in the JSON output) (default not grouped)")
+	rootCmd.Flags().String("policy-api-version", corednsrunner.PolicyAPIVersionV1, "apiVersion of the suggested NetworkPolicies e.g., extensions/v1beta1 for older clusters. One of: networking.k8s.io/v1, extensions/v1beta1")
+	rootCmd.Flags().String("policy-granularity", corednsrunner.PolicyGranularitySingle, "How the peers are split into the suggested NetworkPolicies. One of: single (one policy for all the callers), workload (one policy per calling workload)")
+	rootCmd.Flags().Bool("target-all-services", false, "Finds the services of the pod by their endpoints having the pod IP instead of by their selectors e.g., for services without a selector (default false)")
+	rootCmd.Flags().Bool("show-commands", false, "Prints the kubectl equivalents of what kico does for every pod to stderr e.g., for reproducing the steps manually (default false)")
+	rootCmd.Flags().Bool("single-pass", false, "Parses the CoreDNS logs while waiting for the relevant logs instead of reading them again after waiting, i.e., reads the logs once. Stops once the logs caught up or --wait-for-logs elapses (default false)")
+	rootCmd.Flags().Duration("wait-for-connection", 0, "Follows the logs up to the duration for the first connection to the pod if there is none in the existing logs e.g., right after a deploy (default not waiting)")
+	rootCmd.Flags().StringP("wait-for-logs", "w", defaultWaitDurationForLogs, "Waits for relevant logs to appear. Bounds the wait for all the CoreDNS pods together")
+	rootCmd.Flags().Duration("timeout-per-pod-log", 0, "Bounds the wait for the relevant logs of every CoreDNS pod independently. The wait for all the pods is still bounded by --wait-for-logs, so only a shorter timeout has an effect (default --wait-for-logs)")
+	rootCmd.Flags().StringP("output", "o", defaultOutput, "Output format. One of: text, wide, table, json, wide-json, yaml, markdown")
+	rootCmd.Flags().Bool("output-policy-list", false, "Prints the suggested NetworkPolicies of all the pods as a single 'kind: List' object, implies --suggest-netpol (default false)")
+	rootCmd.Flags().Bool("policy-only", false, "Prints only the suggested NetworkPolicy YAML e.g., to pipe it to kubectl apply -f -, implies --suggest-netpol (default false)")
+	rootCmd.Flags().Bool("compact", false, "Prints only a single line per pod with the number of callers and their namespaces e.g., user-db [3 callers / 2 ns] (default false)")
+	rootCmd.Flags().Bool("output-namespaces", false, "Prints the number of calling pods per namespace along with the services they called (default false)")
+	rootCmd.Flags().String("output-dir", "", "Writes <pod-name>.connections.json and <pod-name>.policy.yaml for every pod to the directory")
+	rootCmd.Flags().Bool("watch", false, "Keeps watching the logs for new incoming connections (default false)")
+	rootCmd.Flags().String("watch-mode", corednsrunner.WatchModeStream, "How --watch gets the new logs. One of: stream (follows the logs), poll (fetches the new logs every --poll-interval)")
+	rootCmd.Flags().Duration("peer-ttl", 0, "Removes a caller not seen for the duration from the suggested NetworkPolicy, requires --watch (default never)")
+	rootCmd.Flags().Duration("poll-interval", corednsrunner.DefaultPollInterval, "Interval between the polls of --watch-mode poll")
+	rootCmd.Flags().Bool("insecure-skip-tls-verify", false, "The API server certificate is not verified. This makes the connection insecure (default false)")
+	rootCmd.Flags().String("certificate-authority", "", "Path to a cert file of the certificate authority of the API server (default uses the kubeconfig)")
+	rootCmd.Flags().StringSlice("context", nil, "Comma separated kubeconfig contexts to run against (default uses current context)")
+	rootCmd.Flags().String("coredns-namespace", corednsrunner.DefaultCoreDNSNamespace, "Namespace where the CoreDNS pods run")
+	rootCmd.Flags().String("coredns-selector", corednsrunner.DefaultCoreDNSSelector, "Label selector of the CoreDNS pods")
+	rootCmd.Flags().String("coredns-field-selector", "", "Field selector to narrow down the CoreDNS pods e.g., status.phase=Running (default none)")
+	rootCmd.Flags().Bool("explain", false, "Annotates each peer in the suggested NetworkPolicy with the pods and services it was created from. With --output json or yaml, the rationale is listed under rationale instead (default false)")
+	rootCmd.Flags().StringSlice("ignore-labels", corednsrunner.DefaultIgnoredPodLabels, "Pod labels which are not used in the suggested NetworkPolicy")
+	rootCmd.Flags().Bool("interactive", false, "Asks which of the discovered peers to include in the suggested NetworkPolicy, implies --suggest-netpol (default false)")
+	rootCmd.Flags().String("verify-policy", "", "Prints which callers of the pod the NetworkPolicy in the YAML file allows or blocks and which of its peers weren't observed, instead of the callers (default none)")
+	rootCmd.Flags().String("from", "", "Prints only whether the pod (<pod-name> in the namespace of the target or <namespace>/<pod-name>) connects to the target pod along with the number of queries (default none)")
+	rootCmd.Flags().String("to", "", "The target pod, same as the pod name argument e.g., kico --from <pod-name> --to <pod-name>")
+	rootCmd.Flags().String("ip", "", "Finds the pod by its IP instead of the pod name")
+	rootCmd.Flags().Bool("include-label-selector-only", false, "Selects every peer of the suggested NetworkPolicy only by the fewest labels which select just the pods of its workload in its namespace e.g., only app instead of all the labels (default false)")
+	rootCmd.Flags().StringSlice("label-key-priority", corednsrunner.DefaultLabelKeyPriority, "Order in which the label keys are tried for --include-label-selector-only. The other keys are tried alphabetically after them")
+	rootCmd.Flags().Bool("include-ipblocks", false, "Also allows the callers which couldn't be resolved to pods (e.g., from outside the cluster) as ipBlock peers in a separate ingress rule of the suggested NetworkPolicy (default false)")
+	rootCmd.Flags().Int("ipblock-prefix-length", 32, "Summarizes the IPv4 ipBlock peers of --include-ipblocks into CIDRs with the prefix length e.g., 24. IPv6 IPs are always allowed as /128")
+	rootCmd.Flags().Bool("merge-subset-peers", false, "Merges a peer into another peer whose labels are a subset of its labels in the suggested NetworkPolicy. This can allow more pods (default false)")
+	rootCmd.Flags().Bool("large-response", false, "Prints the services of the pod with DNS responses over 512 bytes, which likely fall back to TCP (default false)")
+	rootCmd.Flags().Bool("no-color", false, "Disables the colors of the text output. Colors are used only if stdout is a terminal and NO_COLOR is not set (default false)")
+	rootCmd.Flags().String("log-backend", corednsrunner.LogBackendPods, "Where the CoreDNS logs are read from. One of: pods (the logs of the CoreDNS pods), loki (queries --loki-url), file (reads --log-file)")
+	rootCmd.Flags().Bool("fail-on-unresolved", false, "Exits with a non-zero code if any caller IP couldn't be resolved to a pod e.g., from outside the cluster or a misconfigured endpoint. The unresolved IPs are printed (default false)")
+	rootCmd.Flags().StringArray("log-file", nil, "CoreDNS log file for --log-backend file. .gz files and .tar.gz (or .tgz) archives of log files are decompressed. Can be repeated")
+	rootCmd.Flags().String("loki-url", "", "Base URL of Loki for --log-backend loki e.g., http://loki.monitoring:3100")
+	rootCmd.Flags().String("loki-query", "", "LogQL query selecting the CoreDNS logs for --log-backend loki (default {namespace=\"<coredns-namespace>\", container=\"coredns\"})")
+	rootCmd.Flags().Duration("loki-since", corednsrunner.DefaultLokiSince, "How far back the CoreDNS logs are queried for --log-backend loki")
+	rootCmd.Flags().Int("max-coredns-pods", 0, "Reads the logs of only the first N CoreDNS pods by name for speed. Connections served only by the other pods are missed (default all the pods)")
+	rootCmd.Flags().Int("max-peers", 0, "A NetworkPolicy with more peers is not suggested, a warning with the top calling namespaces is printed instead (default no limit)")
+	rootCmd.Flags().String("audit-file", "", "Writes the evidence behind the suggested NetworkPolicy as JSON to the file, requires a single pod and context, implies --suggest-netpol (default none)")
+	rootCmd.Flags().String("dump-resources", "", "Writes the pods, services, endpoints and namespaces fetched for the pod to the file as YAML e.g., for reproducing an issue, requires a single pod and context (default none)")
+	rootCmd.Flags().Bool("dump-logs", false, "Adds the raw CoreDNS logs to --dump-resources (default false)")
+	rootCmd.Flags().Bool("any", false, "Prints only yes or no depending on whether anything connects to the pod. With --watch, waits for the first connection (default false)")
+	rootCmd.Flags().Bool("anonymize", false, "Replaces pod, namespace, service, workload and node names and label keys and values with hashes in the output. The mapping is printed to stderr (default false)")
+	rootCmd.Flags().Float32("qps", 0, "Maximum queries per second to the K8s API server (default uses client-go default of 5)")
+	rootCmd.Flags().Int("burst", 0, "Maximum burst of queries to the K8s API server (default uses client-go default of 10)")
+	rootCmd.Flags().String("cluster-domain", "", "Domain of the service FQDNs e.g., cluster.local (default detected from /etc/resolv.conf of the pod or the Corefile of the CoreDNS ConfigMap, falling back to cluster.local)")
+	rootCmd.Flags().String("continuation-prefix", "", "Lines starting with the prefix are joined to the previous line before parsing e.g., for a multi-line CoreDNS log format or wrapped long queries (default none)")
+	rootCmd.Flags().String("log-level-marker", corednsrunner.DefaultLogFilter.LogLevelMarker, "Only CoreDNS logs starting with the marker are considered")
+	rootCmd.Flags().StringSlice("exclude-namespaces", nil, "Comma separated namespaces whose pods are ignored as callers (default none)")
+	rootCmd.Flags().StringSlice("exclude-pod", nil, "Comma separated pods (<pod-name> or <namespace>/<pod-name>) ignored as callers (default none)")
+	rootCmd.Flags().String("exclude-pod-selector", "", "Label selector of the pods ignored as callers e.g., app=prometheus (default none)")
+	rootCmd.Flags().Bool("exclude-system", false, "Ignores callers from kube-system, kube-public and kube-node-lease namespaces (default false)")
+	rootCmd.Flags().Bool("detect-host-network", false, "Reports queries from node IPs as host network connections with the host network pods on the node instead of matching them to a pod, requires permission to list nodes (default false)")
+	rootCmd.Flags().String("dns-service-name", corednsrunner.DefaultDNSServiceName, "Service in the CoreDNS namespace whose selector and ports are used in the DNS egress rule of --smart-dns-egress")
+	rootCmd.Flags().String("sink-url", "", "HTTP endpoint every new connection is POSTed to as a line of JSON e.g., to export connections in --watch mode (default none)")
+	rootCmd.Flags().Duration("sink-timeout", defaultSinkTimeout, "Timeout of every request to --sink-url")
+	rootCmd.Flags().Bool("smart-dns-egress", false, "Also suggests a NetworkPolicy allowing DNS egress to CoreDNS for the calling workloads whose existing egress NetworkPolicies don't allow it, requires --suggest-netpol (default false)")
+	rootCmd.Flags().Bool("resolve-stale-pod", false, "If the pod doesn't exist anymore (e.g., after a rollout), uses a current pod of the workload it belonged to (default false)")
+	rootCmd.Flags().String("target-fqdn", "", "Only this FQDN is used for matching instead of the FQDNs of the services selecting the pod e.g., user-db.sock-shop.svc.cluster.local.")
+	rootCmd.Flags().Bool("match-workload-pod-ips", false, "With a workload (e.g., deployment/user-db), also counts the queries for any of its pods as incoming connections i.e., for the pod A record, the hostname through a headless service or a reverse (PTR) lookup of the IP with --include-ptr (default false)")
+	rootCmd.Flags().String("fqdn-from-annotation", "", "Key of the pod annotation with the comma separated FQDNs of the pod e.g., the canonical service names. If the pod has the annotation, its FQDNs are used instead of the FQDNs of the services selecting the pod (default none)")
+	rootCmd.Flags().StringArray("extra-target-fqdn", nil, "Additional FQDN (or pattern with *) that points to the pod e.g., an alias served by the CoreDNS rewrite plugin. Can be repeated")
+	rootCmd.Flags().Bool("strict-fqdn", false, "Only considers the CoreDNS logs where the cluster domain ends a well-formed FQDN followed by a whitespace, rejecting longer names which merely contain it (default false)")
+	rootCmd.Flags().Bool("include-ptr", false, "Reverse (PTR) lookups of the pod IP are considered as connections too, can be noisy (default false)")
+	rootCmd.Flags().Bool("require-noerror", corednsrunner.DefaultLogFilter.RequireNoError, "Only CoreDNS logs of successful (NOERROR) queries are considered")
+	rootCmd.Flags().Bool("only-new", false, "Prints only incoming connections not seen in the existing logs, requires --watch (default false)")
 }
 
-func run(toPodName string, toPodNamespace string, suggestNetPol bool, concurrency int, waitForLogs time.Duration) error {
+func run(toPodNames []string, toPodNamespace string, o *options) error {
 	apiConfig, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
 	if err != nil {
 		return err
 	}
 
-	restConfig, err := clientcmd.NewDefaultClientConfig(*apiConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
-	if err != nil {
-		return err
+	// empty context means the current context
+	kubeContexts := o.kubeContexts
+	if len(kubeContexts) == 0 {
+		kubeContexts = []string{""}
 	}
 
-	if toPodNamespace == "" {
+	for _, kubeContext := range kubeContexts {
+		if len(kubeContexts) > 1 && corednsrunner.TextOutput(o.output) {
+			fmt.Fprintf(os.Stderr, "\n==> context: %s <==\n", kubeContext)
+		}
 
-		toPodNamespace = apiConfig.Contexts[apiConfig.CurrentContext].Namespace
-		if toPodNamespace == "" {
-			toPodNamespace = "default"
+		if err := runInContext(apiConfig, kubeContext, toPodNames, toPodNamespace, o); err != nil {
+			if kubeContext == "" {
+				kubeContext = apiConfig.CurrentContext
+			}
+			return fmt.Errorf("context %s: %w", kubeContext, err)
+		}
+		if o.ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "interrupted, skipping the remaining contexts")
+			return nil
 		}
 	}
 
-	r, err := corednsrunner.Initialize(&corednsrunner.InitConfig{
-		ToPodName:            toPodName,
-		ToPodNamespace:       toPodNamespace,
-		Config:               restConfig,
-		SuggestNetworkPolicy: suggestNetPol,
-		Concurrency:          concurrency,
-		WaitForLogsDuration:  waitForLogs,
-	})
+	return nil
+}
+
+// runInContext runs kico for every pod against the cluster of the kubeconfig context
+func runInContext(apiConfig *clientcmdapi.Config, kubeContext string, toPodNames []string, toPodNamespace string, o *options) error {
+	if kubeContext == "" {
+		kubeContext = apiConfig.CurrentContext
+	}
+	if _, ok := apiConfig.Contexts[kubeContext]; !ok {
+		return fmt.Errorf("context `%s` not found in the kubeconfig", kubeContext)
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	if o.insecureSkipTLS || o.certificateAuthority != "" {
+		// overrides the certificate authority in the kubeconfig
+		overrides.ClusterInfo.InsecureSkipTLSVerify = o.insecureSkipTLS
+		overrides.ClusterInfo.CertificateAuthority = o.certificateAuthority
+	}
+
+	clientConfig := clientcmd.NewDefaultClientConfig(*apiConfig, overrides)
+	restConfig, err := clientConfig.ClientConfig()
 	if err != nil {
 		return err
 	}
 
-	if err := r.Run(); err != nil {
-		return err
+	if o.qps > 0 {
+		restConfig.QPS = o.qps
+	}
+	if o.burst > 0 {
+		restConfig.Burst = o.burst
+	}
+
+	if toPodNamespace == "" && o.namespaceFromSA && apiConfig.Contexts[kubeContext].Namespace == "" {
+		// running as a pod e.g., a Job, the namespace of the pod is more intuitive than `default`
+		ns, err := serviceAccountNamespace()
+		if err != nil {
+			log.Printf("err: %v couldn't read the namespace of the service account, defaulting to the namespace of the context", err)
+		} else {
+			toPodNamespace = ns
+		}
+	}
+	if toPodNamespace == "" {
+		// the namespace of the context used for the API calls
+		// (falls back to `default` if the context doesn't have one)
+		toPodNamespace, _, err = clientConfig.Namespace()
+		if err != nil {
+			return err
+		}
+	}
+
+	outputDir := o.outputDir
+	if outputDir != "" && len(o.kubeContexts) > 1 {
+		// avoid overwriting the files of other contexts
+		outputDir = filepath.Join(outputDir, kubeContext)
+	}
+
+	// the namespaces, endpoints and CoreDNS pods are the same for every pod
+	cache := corednsrunner.NewCache(corednsrunner.DefaultCacheTTL)
+
+	for _, toPodName := range toPodNames {
+		if len(toPodNames) > 1 && corednsrunner.TextOutput(o.output) && !o.compact && !o.policyOnly {
+			fmt.Fprintf(os.Stderr, "\n==> pod: %s, ns: %s <==\n\n", o.anonymizer.Pod(toPodName), o.anonymizer.Namespace(toPodNamespace))
+		}
+
+		r, err := corednsrunner.Initialize(&corednsrunner.InitConfig{
+			ToPodName:            toPodName,
+			ToPodNamespace:       toPodNamespace,
+			ToPodIP:              o.ip,
+			Config:               restConfig,
+			SuggestNetworkPolicy: o.suggestNetPol,
+			Concurrency:          o.concurrency,
+			WaitForLogsDuration:  o.waitForLogs,
+			TimeoutPerPodLog:     o.timeoutPerPod,
+			Output:               o.output,
+			Watch:                o.watch,
+			OnlyNew:              o.onlyNew,
+			Explain:              o.explain,
+			OutputDir:            outputDir,
+			IgnoredPodLabels:     o.ignoreLabels,
+			LogFilter:            o.logFilter,
+			CoreDNSNamespace:     o.corednsNamespace,
+			CoreDNSSelector:      o.corednsSelector,
+			CoreDNSFieldSelector: o.corednsFieldSelector,
+			Context:              kubeContext,
+			Anonymizer:           o.anonymizer,
+			PolicyList:           o.policyList,
+			ResolveStalePod:      o.resolveStalePod,
+			SmartDNSEgress:       o.smartDNSEgress,
+			ExcludedNamespaces:   o.excludeNs,
+			Compact:              o.compact,
+			ExcludedPods:         o.excludePods,
+			ExcludedPodSelector:  o.excludePodSelector,
+			PolicyOnly:           o.policyOnly,
+			OutputNamespaces:     o.outputNamespaces,
+			Interactive:          o.interactive,
+			DNSServiceName:       o.dnsServiceName,
+			DetectHostNetwork:    o.detectHostNetwork,
+			Sink:                 o.sink,
+			MaxPeers:             o.maxPeers,
+			MaxCoreDNSPods:       o.maxCoreDNSPods,
+			ShowCommands:         o.showCommands,
+			TargetAllServices:    o.targetAllServices,
+			MinimalPeerLabels:    o.minimalPeerLabels,
+			LabelKeyPriority:     o.labelKeyPriority,
+			MergeSubsetPeers:     o.mergeSubsetPeers,
+			WatchMode:            o.watchMode,
+			PollInterval:         o.pollInterval,
+			PeerTTL:              o.peerTTL,
+			AnyConnection:        o.anyConnection,
+			Loki:                 o.loki,
+			LogFiles:             o.logFiles,
+			FailOnUnresolved:     o.failOnUnresolved,
+			LargeResponse:        o.largeResponse,
+			AuditFile:            o.auditFile,
+			DumpResources:        o.dumpResources,
+			DumpLogs:             o.dumpLogs,
+			WaitForConnection:    o.waitForConnection,
+			SinglePass:           o.singlePass,
+			PolicyGranularity:    o.policyGranularity,
+			Color:                o.color,
+			FromPodName:          o.from,
+			VerifyPolicy:         o.verifyPolicy,
+			GroupBy:              o.groupBy,
+			ResolveServices:      o.resolveServices,
+			PolicyAPIVersion:     o.policyAPIVersion,
+			Ctx:                  o.ctx,
+			Cache:                cache,
+			TargetFQDN:           o.targetFQDN,
+			FQDNAnnotation:       o.fqdnAnnotation,
+			WorkloadPodIPs:       o.workloadPodIPs,
+			IncludeIPBlocks:      o.includeIPBlocks,
+			IPBlockPrefixLength:  o.ipBlockPrefixLength,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := r.Run(); err != nil {
+			var unresolved *corednsrunner.ErrUnresolvedCallers
+			if !errors.As(err, &unresolved) {
+				return err
+			}
+			// the other pods are still analyzed, kico fails at the end
+			log.Printf("err: %v", err)
+			o.unresolvedFound = true
+		}
+		if o.ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "interrupted, skipping the remaining pods")
+			return nil
+		}
 	}
 
 	return nil
@@ -0,0 +1,28 @@
+/*
+Copyright © 2022 Suraj Banakar surajrbanakar@gmail.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// serviceAccountNamespaceFile has the namespace of the pod kico is running in
+// https://kubernetes.io/docs/tasks/run-application/access-api-from-pod/#directly-accessing-the-rest-api
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// serviceAccountNamespace reads the namespace of the pod kico is running in
+// from the file mounted with its service account token
+func serviceAccountNamespace() (string, error) {
+	b, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", err
+	}
+	ns := strings.TrimSpace(string(b))
+	if ns == "" {
+		return "", fmt.Errorf("%s is empty", serviceAccountNamespaceFile)
+	}
+	return ns, nil
+}
@@ -0,0 +1,36 @@
+/*
+Copyright © 2022 Suraj Banakar surajrbanakar@gmail.com
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptContext returns a context cancelled on the first SIGINT (Ctrl-C) or SIGTERM
+// so that kico stops waiting and prints what it found so far
+// The next signal isn't caught anymore i.e., it exits right away
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Fprintln(os.Stderr, "\ninterrupted, printing the partial results (interrupt again to exit right away)...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,219 @@
+package corednsrunner
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Anonymizer consistently replaces pod, namespace, service, workload and node names
+// and label keys and values with hashes so that the output can be shared publicly
+// The hashes are salted with a random salt, so they are stable only within a run
+// A nil *Anonymizer leaves the names as they are
+type Anonymizer struct {
+	mu    sync.Mutex
+	salt  []byte
+	names map[string]string
+}
+
+// NewAnonymizer creates an Anonymizer with a random salt
+func NewAnonymizer() (*Anonymizer, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &Anonymizer{salt: salt, names: map[string]string{}}, nil
+}
+
+// name returns the anonymized name e.g., `ns-1a2b3c4d` for `sock-shop`
+func (a *Anonymizer) name(prefix, real string) string {
+	if a == nil || real == "" {
+		return real
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	h := sha256.Sum256(append(append([]byte{}, a.salt...), real...))
+	alias := fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(h[:])[:8])
+	a.names[alias] = real
+	return alias
+}
+
+// Pod anonymizes a pod name
+func (a *Anonymizer) Pod(name string) string {
+	return a.name("pod", name)
+}
+
+// Namespace anonymizes a namespace name
+func (a *Anonymizer) Namespace(name string) string {
+	return a.name("ns", name)
+}
+
+// fqdn anonymizes the service and the namespace in a service FQDN
+func (a *Anonymizer) fqdn(fqdn string) string {
+	if a == nil {
+		return fqdn
+	}
+	// the cluster domain is kept e.g., `svc-1a2b3c4d.ns-5e6f7a8b.svc.cluster.local.`
+	i := strings.Index(fqdn, ".svc.")
+	if i < 0 {
+		return a.name("fqdn", fqdn)
+	}
+
+	s := serviceFromFQDN(fqdn, fqdn[i:])
+	anon := fmt.Sprintf("%s.%s%s", a.name("svc", s.Name), a.Namespace(s.Namespace), fqdn[i:])
+	// the hostname of a pod of a headless service e.g., user-db-0.user-db.sock-shop.svc.cluster.local.
+	if parts := strings.Split(fqdn[:i], "."); len(parts) > 2 {
+		anon = a.name("host", strings.Join(parts[:len(parts)-2], ".")) + "." + anon
+	}
+	return anon
+}
+
+func (a *Anonymizer) workload(w Workload) Workload {
+	if a == nil {
+		return w
+	}
+	return Workload{
+		Kind:      w.Kind,
+		Name:      a.name(strings.ToLower(w.Kind), w.Name),
+		Namespace: a.Namespace(w.Namespace),
+	}
+}
+
+func (a *Anonymizer) labels(l map[string]string) map[string]string {
+	if a == nil || l == nil {
+		return l
+	}
+	anon := map[string]string{}
+	for k, v := range l {
+		anon[a.name("labelkey", k)] = a.name("label", v)
+	}
+	return anon
+}
+
+func (a *Anonymizer) labelSelector(s *metav1.LabelSelector) *metav1.LabelSelector {
+	if a == nil || s == nil {
+		return s
+	}
+	anon := &metav1.LabelSelector{MatchLabels: a.labels(s.MatchLabels)}
+	for _, e := range s.MatchExpressions {
+		values := []string{}
+		for _, v := range e.Values {
+			values = append(values, a.name("label", v))
+		}
+		anon.MatchExpressions = append(anon.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      a.name("labelkey", e.Key),
+			Operator: e.Operator,
+			Values:   values,
+		})
+	}
+	return anon
+}
+
+// networkPolicy returns an anonymized copy of the NetworkPolicy
+func (a *Anonymizer) networkPolicy(n *networkingv1.NetworkPolicy) *networkingv1.NetworkPolicy {
+	if a == nil || n == nil {
+		return n
+	}
+
+	anon := n.DeepCopy()
+	anon.Name = a.name("netpol", n.Name)
+	anon.Namespace = a.Namespace(n.Namespace)
+	anon.Spec.PodSelector = *a.labelSelector(&n.Spec.PodSelector)
+	for i, rule := range anon.Spec.Ingress {
+		for j, peer := range rule.From {
+			anon.Spec.Ingress[i].From[j].PodSelector = a.labelSelector(peer.PodSelector)
+			anon.Spec.Ingress[i].From[j].NamespaceSelector = a.labelSelector(peer.NamespaceSelector)
+		}
+	}
+	for i, rule := range anon.Spec.Egress {
+		for j, peer := range rule.To {
+			anon.Spec.Egress[i].To[j].PodSelector = a.labelSelector(peer.PodSelector)
+			anon.Spec.Egress[i].To[j].NamespaceSelector = a.labelSelector(peer.NamespaceSelector)
+		}
+	}
+	return anon
+}
+
+// PrintMapping prints the anonymized names along with the real names
+// so that the user can de-anonymize the output
+func (a *Anonymizer) PrintMapping(w io.Writer) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	aliases := []string{}
+	for alias := range a.names {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "ANONYMIZED NAMES")
+	fmt.Fprintln(w, "----------------")
+	for _, alias := range aliases {
+		fmt.Fprintf(w, "%s: %s\n", alias, a.names[alias])
+	}
+}
+
+func (a *Anonymizer) connection(c Connection) Connection {
+	if a == nil {
+		return c
+	}
+	return Connection{
+		FromPod:       a.Pod(c.FromPod),
+		FromNamespace: a.Namespace(c.FromNamespace),
+		ToFQDN:        a.fqdn(c.ToFQDN),
+		QueryID:       c.QueryID,
+		Transport:     c.Transport,
+		Relation:      c.Relation,
+		Transports:    c.Transports,
+		LastSeen:      c.LastSeen,
+	}
+}
+
+func (a *Anonymizer) caller(c Caller) Caller {
+	if a == nil {
+		return c
+	}
+	anon := Caller{
+		Pod:          a.Pod(c.Pod),
+		Namespace:    a.Namespace(c.Namespace),
+		Node:         a.name("node", c.Node),
+		Labels:       a.labels(c.Labels),
+		Annotations:  a.labels(c.Annotations),
+		Owners:       []Workload{},
+		ServiceFQDNs: []string{},
+	}
+	for _, o := range c.Owners {
+		anon.Owners = append(anon.Owners, a.workload(o))
+	}
+	for _, f := range c.ServiceFQDNs {
+		anon.ServiceFQDNs = append(anon.ServiceFQDNs, a.fqdn(f))
+	}
+	return anon
+}
+
+func (a *Anonymizer) unresolved(u Unresolved) Unresolved {
+	if a == nil {
+		return u
+	}
+	anon := Unresolved{IP: u.IP, Port: u.Port, ToFQDN: a.fqdn(u.ToFQDN), Node: a.name("node", u.Node)}
+	for _, p := range u.HostNetworkPods {
+		ns, name, _ := strings.Cut(p, "/")
+		anon.HostNetworkPods = append(anon.HostNetworkPods, a.Namespace(ns)+"/"+a.Pod(name))
+	}
+	return anon
+}
@@ -0,0 +1,113 @@
+package corednsrunner
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// Audit is the evidence behind a suggested NetworkPolicy
+// e.g., for recording the provenance of the policy in compliance workflows
+type Audit struct {
+	APIVersion  string      `json:"apiVersion"`
+	GeneratedAt time.Time   `json:"generatedAt"`
+	Target      Target      `json:"target"`
+	Parameters  Parameters  `json:"parameters"`
+	Window      AuditWindow `json:"window"`
+	// LogLinesScanned is the number of log lines scanned per CoreDNS pod
+	// (or `loki` for LogBackendLoki)
+	LogLinesScanned map[string]int              `json:"logLinesScanned"`
+	Peers           []AuditPeer                 `json:"peers"`
+	NetworkPolicy   *networkingv1.NetworkPolicy `json:"networkPolicy"`
+}
+
+// AuditWindow is the time window of the analyzed logs
+type AuditWindow struct {
+	// LogBackend is where the logs were read from
+	LogBackend string `json:"logBackend"`
+	// Since is not set for LogBackendPods because the window
+	// is whatever the CoreDNS pods retained
+	Since *time.Time `json:"since,omitempty"`
+	Until time.Time  `json:"until"`
+}
+
+// AuditPeer is a resolved caller with the number of its queries
+type AuditPeer struct {
+	Connection
+	Occurrences int `json:"occurrences"`
+}
+
+// writeAudit writes the Audit of the suggested NetworkPolicy as JSON to path
+func (r *Runner) writeAudit(path string) error {
+	n, _, err := r.buildNetPol()
+	if err != nil {
+		return err
+	}
+
+	a := Audit{
+		APIVersion:  ResultAPIVersion,
+		GeneratedAt: time.Now().UTC(),
+		Target: Target{
+			Pod:          r.anonymizer.Pod(r.toPod.Name),
+			Namespace:    r.anonymizer.Namespace(r.toPod.Namespace),
+			IP:           r.toPodIP,
+			Context:      r.kubeContext,
+			ServiceFQDNs: []string{},
+		},
+		Parameters: r.parameters(),
+		Window: AuditWindow{
+			LogBackend: LogBackendPods,
+			Until:      r.logsUntil.UTC(),
+		},
+		LogLinesScanned: r.linesScanned,
+		Peers:           []AuditPeer{},
+		NetworkPolicy:   n,
+	}
+	if r.loki != nil {
+		since := r.logsUntil.Add(-r.loki.Since).UTC()
+		a.Window.LogBackend = LogBackendLoki
+		a.Window.Since = &since
+	}
+	for _, f := range r.toPodServiceFQDNs {
+		a.Target.ServiceFQDNs = append(a.Target.ServiceFQDNs, r.anonymizer.fqdn(f))
+	}
+
+	for hostname, mappings := range r.hostnamePodMapping {
+		for _, m := range mappings {
+			a.Peers = append(a.Peers, AuditPeer{
+				Connection: r.anonymizer.connection(Connection{
+					FromPod:       m.podname,
+					FromNamespace: m.namespace,
+					ToFQDN:        hostname,
+					QueryID:       m.queryID,
+					Transport:     m.transport,
+					Relation:      r.relation(m.podname, m.namespace),
+				}),
+				Occurrences: m.count,
+			})
+		}
+	}
+	sort.Slice(a.Peers, func(i, j int) bool {
+		if a.Peers[i].ToFQDN != a.Peers[j].ToFQDN {
+			return a.Peers[i].ToFQDN < a.Peers[j].ToFQDN
+		}
+		if a.Peers[i].FromNamespace != a.Peers[j].FromNamespace {
+			return a.Peers[i].FromNamespace < a.Peers[j].FromNamespace
+		}
+		return a.Peers[i].FromPod < a.Peers[j].FromPod
+	})
+
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	log.Infof("wrote the audit to %s\n", path)
+	return nil
+}
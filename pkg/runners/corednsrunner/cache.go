@@ -0,0 +1,138 @@
+package corednsrunner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultCacheTTL is how long the resources in a Cache are reused
+const DefaultCacheTTL = 5 * time.Minute
+
+// Cache keeps the namespaces, endpoints and CoreDNS pods of a cluster
+// so that analyzing multiple pods in a single process doesn't fetch them every time
+// A Cache must be used only with a single cluster
+// A nil *Cache fetches the resources every time
+type Cache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+
+	namespaces   *v1.NamespaceList
+	endpoints    map[string]*v1.EndpointsList
+	resourcesAge time.Time
+	// pods are keyed by the namespace, label selector and field selector
+	pods    map[string]*v1.PodList
+	podsAge map[string]time.Time
+}
+
+// NewCache creates a Cache which reuses the resources for ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		pods:    map[string]*v1.PodList{},
+		podsAge: map[string]time.Time{},
+	}
+}
+
+// namespacesEndpoints returns all the namespaces and the endpoints per namespace
+// The endpoints are listed per namespace by up to concurrency workers
+// so that the startup doesn't slow down with the number of namespaces
+// The first error stops the remaining calls and is returned
+func (c *Cache) namespacesEndpoints(clientset kubernetes.Interface, concurrency int) (*v1.NamespaceList, map[string]*v1.EndpointsList, error) {
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.namespaces != nil && time.Since(c.resourcesAge) < c.ttl {
+			return c.namespaces, c.endpoints, nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nsList, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var e error
+	allEps := map[string]*v1.EndpointsList{}
+	// limits the number of List calls in flight
+	sem := make(chan struct{}, concurrency)
+	for _, n := range nsList.Items {
+		namespace := n.Name
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			eList, err := clientset.CoreV1().Endpoints(namespace).List(ctx, metav1.ListOptions{})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if e == nil {
+					e = err
+					cancel()
+				}
+				return
+			}
+			allEps[namespace] = eList
+		}()
+	}
+	wg.Wait()
+	if e != nil {
+		return nil, nil, e
+	}
+
+	if c != nil {
+		c.namespaces = nsList
+		c.endpoints = allEps
+		c.resourcesAge = time.Now()
+	}
+	return nsList, allEps, nil
+}
+
+// invalidateNamespacesEndpoints makes the next namespacesEndpoints fetch the resources
+func (c *Cache) invalidateNamespacesEndpoints() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.namespaces = nil
+}
+
+// podList returns the pods in the namespace matching the label and the field selector
+func (c *Cache) podList(clientset kubernetes.Interface, namespace, labelSelector, fieldSelector string) (*v1.PodList, error) {
+	key := namespace + "/" + labelSelector + "/" + fieldSelector
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if p, ok := c.pods[key]; ok && time.Since(c.podsAge[key]) < c.ttl {
+			return p, nil
+		}
+	}
+
+	podList, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c != nil {
+		c.pods[key] = podList
+		c.podsAge[key] = time.Now()
+	}
+	return podList, nil
+}
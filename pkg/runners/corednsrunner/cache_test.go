@@ -0,0 +1,89 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeNamespacesEndpoints returns n namespaces with i endpoints in the namespace `ns-<i>`
+func fakeNamespacesEndpoints(n int) []runtime.Object {
+	objects := []runtime.Object{}
+	for i := 0; i < n; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		objects = append(objects, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+		for j := 0; j < i; j++ {
+			objects = append(objects, &v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", j), Namespace: ns}})
+		}
+	}
+	return objects
+}
+
+func TestNamespacesEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		namespaces  int
+		concurrency int
+	}{
+		{name: "sequential", namespaces: 20, concurrency: 1},
+		{name: "fewer workers than namespaces", namespaces: 20, concurrency: 3},
+		{name: "more workers than namespaces", namespaces: 20, concurrency: 50},
+		{name: "concurrency not set", namespaces: 20, concurrency: 0},
+		{name: "no namespaces", namespaces: 0, concurrency: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(fakeNamespacesEndpoints(tt.namespaces)...)
+
+			nsList, allEps, err := NewCache(DefaultCacheTTL).namespacesEndpoints(clientset, tt.concurrency)
+			if err != nil {
+				t.Fatalf("namespacesEndpoints() error = %v", err)
+			}
+			if len(nsList.Items) != tt.namespaces {
+				t.Errorf("got %d namespaces, want %d", len(nsList.Items), tt.namespaces)
+			}
+			if len(allEps) != tt.namespaces {
+				t.Errorf("got endpoints of %d namespaces, want %d", len(allEps), tt.namespaces)
+			}
+			for i := 0; i < tt.namespaces; i++ {
+				ns := fmt.Sprintf("ns-%d", i)
+				if allEps[ns] == nil {
+					t.Errorf("no endpoints list for %s", ns)
+					continue
+				}
+				if len(allEps[ns].Items) != i {
+					t.Errorf("got %d endpoints in %s, want %d", len(allEps[ns].Items), ns, i)
+				}
+				for _, e := range allEps[ns].Items {
+					if e.Namespace != ns {
+						t.Errorf("endpoints %s/%s listed under %s", e.Namespace, e.Name, ns)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNamespacesEndpointsError(t *testing.T) {
+	clientset := fake.NewSimpleClientset(fakeNamespacesEndpoints(10)...)
+	clientset.PrependReactor("list", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "ns-7" {
+			return true, nil, fmt.Errorf("forbidden")
+		}
+		return false, nil, nil
+	})
+
+	c := NewCache(DefaultCacheTTL)
+	if _, _, err := c.namespacesEndpoints(clientset, 3); err == nil {
+		t.Fatal("namespacesEndpoints() error = nil, want the error of ns-7")
+	}
+	if c.namespaces != nil {
+		t.Error("the partial result was cached")
+	}
+}
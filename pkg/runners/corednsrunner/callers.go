@@ -0,0 +1,128 @@
+package corednsrunner
+
+import (
+	"context"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Caller is a calling pod with its metadata (see OutputWideJSON)
+type Caller struct {
+	Pod         string            `json:"pod"`
+	Namespace   string            `json:"namespace"`
+	Node        string            `json:"node,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// Owners is the owner reference chain of the pod
+	// from its controller up to the top-most owner e.g., ReplicaSet, Deployment
+	Owners []Workload `json:"owners"`
+	// ServiceFQDNs are the FQDNs of the target the pod queried
+	ServiceFQDNs []string `json:"serviceFQDNs"`
+}
+
+// callers resolves the pods in hostnamePodMapping to Callers
+// The pods are looked up in the (cached) pod list of their namespace
+// A pod which doesn't exist anymore has no metadata
+func (r *Runner) callers() ([]Caller, error) {
+	callersByPod := map[string]*Caller{}
+	keys := []string{}
+	for _, c := range r.connections() {
+		if c.FromPod == "" {
+			continue
+		}
+		key := c.FromNamespace + "/" + c.FromPod
+		if _, ok := callersByPod[key]; !ok {
+			callersByPod[key] = &Caller{
+				Pod:          c.FromPod,
+				Namespace:    c.FromNamespace,
+				Labels:       map[string]string{},
+				Annotations:  map[string]string{},
+				Owners:       []Workload{},
+				ServiceFQDNs: []string{},
+			}
+			keys = append(keys, key)
+		}
+		callersByPod[key].ServiceFQDNs = append(callersByPod[key].ServiceFQDNs, c.ToFQDN)
+	}
+	sort.Strings(keys)
+
+	podsByNamespace := map[string]map[string]*v1.Pod{}
+	callers := []Caller{}
+	for _, key := range keys {
+		c := callersByPod[key]
+		if _, ok := podsByNamespace[c.Namespace]; !ok {
+			podList, err := r.cache.podList(r.clientset, c.Namespace, "", "")
+			if err != nil {
+				return nil, err
+			}
+			podsByNamespace[c.Namespace] = map[string]*v1.Pod{}
+			for i, p := range podList.Items {
+				podsByNamespace[c.Namespace][p.Name] = &podList.Items[i]
+			}
+		}
+
+		pod, ok := podsByNamespace[c.Namespace][c.Pod]
+		if !ok {
+			log.Debugf("pod %s in ns %s doesn't exist anymore, leaving out its metadata", c.Pod, c.Namespace)
+			callers = append(callers, *c)
+			continue
+		}
+
+		owners, err := r.ownerChain(pod)
+		if err != nil {
+			// e.g., the ReplicaSet or the Job was deleted
+			log.Warnf("couldn't find the owner of the pod %s in ns %s: %v, leaving out its owners", c.Pod, c.Namespace, err)
+			r.addWarning(WarningAPIError, "couldn't find the owner of the pod %s in ns %s: %v", c.Pod, c.Namespace, err)
+			owners = nil
+		}
+		c.Node = pod.Spec.NodeName
+		c.Owners = owners
+		if pod.GetLabels() != nil {
+			c.Labels = pod.GetLabels()
+		}
+		if pod.GetAnnotations() != nil {
+			c.Annotations = pod.GetAnnotations()
+		}
+		callers = append(callers, *c)
+	}
+
+	return callers, nil
+}
+
+// ownerChain walks up the controller references of a pod
+// e.g., [ReplicaSet, Deployment] for a pod of a Deployment
+// A pod without a controller has no owners
+func (r *Runner) ownerChain(pod *v1.Pod) ([]Workload, error) {
+	owners := []Workload{}
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return owners, nil
+	}
+	owners = append(owners, Workload{Kind: ref.Kind, Name: ref.Name, Namespace: pod.Namespace})
+
+	ctx := context.Background()
+	var parent metav1.Object
+	switch ref.Kind {
+	case "ReplicaSet":
+		rs, err := r.clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		parent = rs
+	case "Job":
+		job, err := r.clientset.BatchV1().Jobs(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		parent = job
+	}
+
+	if parent != nil {
+		if ref := metav1.GetControllerOf(parent); ref != nil {
+			owners = append(owners, Workload{Kind: ref.Kind, Name: ref.Name, Namespace: pod.Namespace})
+		}
+	}
+	return owners, nil
+}
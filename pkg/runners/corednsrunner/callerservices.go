@@ -0,0 +1,54 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// callerServices is a calling pod with every service FQDN it used to reach the toPod
+type callerServices struct {
+	pod       string
+	namespace string
+	fqdns     []string
+}
+
+// servicesByCaller collects the service FQDNs per calling pod, sorted by namespace and pod
+func (r *Runner) servicesByCaller() []callerServices {
+	byCaller := map[string]*callerServices{}
+	for _, c := range r.connections() {
+		key := c.FromNamespace + "/" + c.FromPod
+		if byCaller[key] == nil {
+			byCaller[key] = &callerServices{pod: c.FromPod, namespace: c.FromNamespace}
+		}
+		// connections are sorted by the FQDN, so the FQDNs are sorted too
+		byCaller[key].fqdns = append(byCaller[key].fqdns, c.ToFQDN)
+	}
+
+	callers := []callerServices{}
+	for _, c := range byCaller {
+		callers = append(callers, *c)
+	}
+	sort.Slice(callers, func(i, j int) bool {
+		if callers[i].namespace != callers[j].namespace {
+			return callers[i].namespace < callers[j].namespace
+		}
+		return callers[i].pod < callers[j].pod
+	})
+	return callers
+}
+
+// printServicesByCaller prints every service a calling pod used
+// e.g., to spot the callers reaching the pod through more than one service
+func (r *Runner) printServicesByCaller() {
+	printBanner("SERVICES USED PER CALLER")
+	for _, c := range r.servicesByCaller() {
+		fqdns := []string{}
+		for _, f := range c.fqdns {
+			fqdns = append(fqdns, r.colorize(colorService, r.anonymizer.fqdn(f)))
+		}
+		pod := r.colorize(colorPod, r.anonymizer.Pod(c.pod))
+		ns := r.colorize(colorNamespace, r.anonymizer.Namespace(c.namespace))
+		fmt.Printf("pod: %s, ns: %s used services [%s]\n", pod, ns, strings.Join(fqdns, ", "))
+	}
+}
@@ -0,0 +1,171 @@
+package corednsrunner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// DefaultClusterDomain is used if the cluster domain can't be detected
+	DefaultClusterDomain = "cluster.local"
+	// CoreDNSConfigMap is the ConfigMap with the Corefile in the CoreDNS namespace
+	CoreDNSConfigMap = "coredns"
+
+	// ClusterDomainFromResolvConf is when the cluster domain was read from the resolv.conf of the toPod
+	ClusterDomainFromResolvConf = "resolv.conf"
+	// ClusterDomainFromCorefile is when the cluster domain was read from the Corefile
+	ClusterDomainFromCorefile = "Corefile"
+
+	resolvConfPath = "/etc/resolv.conf"
+	// podExecTimeout bounds reading the resolv.conf of a pod e.g., if the exec hangs
+	podExecTimeout = 10 * time.Second
+)
+
+// clusterSuffix is the suffix of the service FQDNs without the trailing dot
+// some CoreDNS configurations log the query name without the trailing dot
+// so the logs are matched against the suffix without the trailing dot
+func (f LogFilter) clusterSuffix() string {
+	if f.ClusterDomain == "" {
+		return ".svc." + DefaultClusterDomain
+	}
+	return ".svc." + f.ClusterDomain
+}
+
+// clusterSuffixIndex returns the index of the first cluster suffix in the log which
+// ends the name i.e., is followed by `.`, a space or the end of the log (-1 if none)
+// so that e.g., `user-db.sock-shop.svc.cluster.localhost` doesn't match `.svc.cluster.local`
+func (f LogFilter) clusterSuffixIndex(rawText string) int {
+	suffix := f.clusterSuffix()
+	for i := 0; i < len(rawText); {
+		si := strings.Index(rawText[i:], suffix)
+		if si < 0 {
+			return -1
+		}
+		si += i
+		end := si + len(suffix)
+		if end == len(rawText) || rawText[end] == '.' || rawText[end] == ' ' {
+			return si
+		}
+		i = si + 1
+	}
+	return -1
+}
+
+// fqdnSuffix is the suffix of the service FQDNs e.g., `.svc.cluster.local.`
+func (f LogFilter) fqdnSuffix() string {
+	return f.clusterSuffix() + "."
+}
+
+// CorefileClusterDomain returns the first zone of the `kubernetes` plugin in the Corefile
+// e.g., `cluster.local` for `kubernetes cluster.local in-addr.arpa ip6.arpa {`
+func CorefileClusterDomain(corefile string) string {
+	scanner := bufio.NewScanner(strings.NewReader(corefile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "kubernetes" && fields[1] != "{" {
+			return strings.TrimSuffix(fields[1], ".")
+		}
+	}
+	return ""
+}
+
+// ResolvConfClusterDomain returns the cluster domain from the `svc.<domain>` search domain
+// the kubelet writes into the resolv.conf of the pods e.g., `cluster.local` for
+// `search sock-shop.svc.cluster.local svc.cluster.local cluster.local`
+func ResolvConfClusterDomain(resolvConf string) string {
+	scanner := bufio.NewScanner(strings.NewReader(resolvConf))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "search" {
+			continue
+		}
+		for _, d := range fields[1:] {
+			if strings.HasPrefix(d, "svc.") && len(d) > len("svc.") {
+				return strings.TrimSuffix(strings.TrimPrefix(d, "svc."), ".")
+			}
+		}
+	}
+	return ""
+}
+
+// PodClusterDomain reads the cluster domain from the resolv.conf of the pod
+// It needs exec access to the pod and `cat` in its first container
+// The exec is given up after podExecTimeout
+func PodClusterDomain(config *rest.Config, clientset kubernetes.Interface, pod *v1.Pod) (string, error) {
+	if len(pod.Spec.Containers) == 0 {
+		return "", fmt.Errorf("pod %s has no containers", pod.Name)
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: pod.Spec.Containers[0].Name,
+			Command:   []string{"cat", resolvConfPath},
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), podExecTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	if err := exec.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		return "", fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	domain := ResolvConfClusterDomain(stdout.String())
+	if domain == "" {
+		return "", fmt.Errorf("no `svc.<domain>` search domain in %s of the pod %s", resolvConfPath, pod.Name)
+	}
+	return domain, nil
+}
+
+// detectClusterDomain reads the cluster domain from the resolv.conf of the toPod
+// and if that fails (e.g., no exec access or no `cat` in the container)
+// from the Corefile of the CoreDNS ConfigMap, which only needs read access
+// It falls back to DefaultClusterDomain if the domain can't be determined
+// The source of the domain is returned along with it (empty for the default)
+func (r *Runner) detectClusterDomain() (string, string) {
+	if r.toPod != nil && r.config != nil {
+		domain, err := PodClusterDomain(r.config, r.clientset, r.toPod)
+		if err == nil {
+			log.Debugf("detected the cluster domain %s from %s of the pod %s", domain, resolvConfPath, r.toPod.Name)
+			return domain, ClusterDomainFromResolvConf
+		}
+		log.Debugf("couldn't read the cluster domain from %s of the pod %s: %v", resolvConfPath, r.toPod.Name, err)
+	}
+
+	cm, err := r.clientset.CoreV1().ConfigMaps(r.corednsNamespace).Get(context.Background(), CoreDNSConfigMap, metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("couldn't get the %s/%s ConfigMap to detect the cluster domain: %v", r.corednsNamespace, CoreDNSConfigMap, err)
+		log.Debugf("defaulting to the cluster domain %s", DefaultClusterDomain)
+		return DefaultClusterDomain, ""
+	}
+
+	domain := CorefileClusterDomain(cm.Data["Corefile"])
+	if domain == "" {
+		log.Debugf("couldn't find the `kubernetes` plugin zone in the Corefile of %s/%s ConfigMap", r.corednsNamespace, CoreDNSConfigMap)
+		log.Debugf("defaulting to the cluster domain %s", DefaultClusterDomain)
+		return DefaultClusterDomain, ""
+	}
+
+	log.Debugf("detected the cluster domain %s from the Corefile", domain)
+	return domain, ClusterDomainFromCorefile
+}
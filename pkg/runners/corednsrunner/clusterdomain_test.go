@@ -0,0 +1,45 @@
+package corednsrunner
+
+import "testing"
+
+func TestResolvConfClusterDomain(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolvConf string
+		want       string
+	}{
+		{
+			name:       "kubelet generated",
+			resolvConf: "search sock-shop.svc.cluster.local svc.cluster.local cluster.local\nnameserver 10.43.0.10\noptions ndots:5\n",
+			want:       "cluster.local",
+		},
+		{
+			name:       "custom domain with the trailing dot",
+			resolvConf: "nameserver 10.43.0.10\nsearch sock-shop.svc.cluster.example. svc.cluster.example. cluster.example.\n",
+			want:       "cluster.example",
+		},
+		{
+			name:       "host network",
+			resolvConf: "search ec2.internal\nnameserver 169.254.169.253\n",
+			want:       "",
+		},
+		{
+			name:       "bare svc",
+			resolvConf: "search svc.\n",
+			want:       "",
+		},
+		{
+			name:       "empty",
+			resolvConf: "",
+			want:       "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolvConfClusterDomain(tt.resolvConf); got != tt.want {
+				t.Errorf("ResolvConfClusterDomain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
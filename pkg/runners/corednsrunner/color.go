@@ -0,0 +1,17 @@
+package corednsrunner
+
+// ANSI colors of the text output (see InitConfig.Color)
+const (
+	colorReset     = "\033[0m"
+	colorNamespace = "\033[36m" // cyan
+	colorPod       = "\033[32m" // green
+	colorService   = "\033[33m" // yellow
+)
+
+// colorize wraps s in the color if colors are enabled
+func (r *Runner) colorize(color, s string) string {
+	if !r.color {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,71 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// kubectlCommands returns the kubectl equivalents of the K8s API calls kico makes for the toPod
+// built from the namespaces and selectors actually used e.g., for reproducing the steps manually
+func (r *Runner) kubectlCommands() []string {
+	kubectl := "kubectl"
+	if r.kubeContext != "" {
+		kubectl = fmt.Sprintf("kubectl --context %s", r.kubeContext)
+	}
+
+	cmds := []string{}
+	if r.toPodIP != "" {
+		cmds = append(cmds, fmt.Sprintf("%s get pods -A --field-selector status.podIP=%s", kubectl, r.toPodIP))
+	} else {
+		cmds = append(cmds, fmt.Sprintf("%s get pod %s -n %s", kubectl, r.toPod.Name, r.toPod.Namespace))
+	}
+
+	corednsPods := fmt.Sprintf("%s get pods -n %s -l %s", kubectl, r.corednsNamespace, r.corednsSelector)
+	if r.corednsFieldSelector != "" {
+		corednsPods = fmt.Sprintf("%s --field-selector %s", corednsPods, r.corednsFieldSelector)
+	}
+	cmds = append(cmds,
+		corednsPods,
+		fmt.Sprintf("%s get namespaces", kubectl),
+		fmt.Sprintf("%s get endpoints -A", kubectl),
+		fmt.Sprintf("%s get services -n %s", kubectl, r.toPodNamespace),
+	)
+	switch r.clusterDomainSource {
+	case ClusterDomainFromResolvConf:
+		cmds = append(cmds, fmt.Sprintf("%s exec %s -n %s -- cat %s", kubectl, r.toPod.Name, r.toPod.Namespace, resolvConfPath))
+	case ClusterDomainFromCorefile:
+		cmds = append(cmds, fmt.Sprintf("%s get configmap %s -n %s -o jsonpath='{.data.Corefile}'", kubectl, CoreDNSConfigMap, r.corednsNamespace))
+	}
+
+	if r.loki != nil {
+		cmds = append(cmds, fmt.Sprintf("logcli --addr %s query '%s' --since %s --forward", r.loki.URL, r.loki.Query, r.loki.Since))
+	} else if len(r.logFiles) > 0 {
+		for _, f := range r.logFiles {
+			if strings.HasSuffix(f, ".tar.gz") || strings.HasSuffix(f, ".tgz") {
+				cmds = append(cmds, fmt.Sprintf("tar -xzOf %s", f))
+				continue
+			}
+			cmds = append(cmds, fmt.Sprintf("zcat -f %s", f))
+		}
+	} else {
+		follow := ""
+		if r.watch {
+			follow = " -f"
+		}
+		for _, pod := range r.coreDNSPods.Items {
+			cmds = append(cmds, fmt.Sprintf("%s logs%s %s -n %s", kubectl, follow, pod.Name, pod.Namespace))
+		}
+	}
+
+	if r.suggestNetworkPolicy {
+		cmds = append(cmds, fmt.Sprintf("%s get networkpolicies -n %s", kubectl, r.toPodNamespace))
+	}
+	return cmds
+}
+
+// printKubectlCommands prints the kubectlCommands to stderr
+func (r *Runner) printKubectlCommands() {
+	printBanner("EQUIVALENT kubectl COMMANDS")
+	fmt.Fprintln(os.Stderr, strings.Join(r.kubectlCommands(), "\n"))
+}
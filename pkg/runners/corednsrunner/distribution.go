@@ -0,0 +1,68 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// distributionBarWidth is the width of the bar of the service with the most callers
+const distributionBarWidth = 40
+
+// callersPerService counts the distinct calling pods per FQDN
+// including the toPod services without callers
+func (r *Runner) callersPerService() map[string]int {
+	counts := map[string]int{}
+	for _, f := range r.toPodServiceFQDNs {
+		counts[f] = 0
+	}
+	for hostname, mappings := range r.hostnamePodMapping {
+		callers := map[string]bool{}
+		for _, m := range mappings {
+			callers[m.namespace+"/"+m.podname] = true
+		}
+		counts[hostname] = len(callers)
+	}
+	return counts
+}
+
+// printDistribution prints the number of callers per service as a text bar chart
+// e.g., to see which of the services of the toPod has the widest client base
+func (r *Runner) printDistribution() {
+	counts := r.callersPerService()
+	if len(counts) == 0 {
+		return
+	}
+
+	fqdns := []string{}
+	most := 0
+	for f, n := range counts {
+		fqdns = append(fqdns, f)
+		if n > most {
+			most = n
+		}
+	}
+	sort.Slice(fqdns, func(i, j int) bool {
+		if counts[fqdns[i]] != counts[fqdns[j]] {
+			return counts[fqdns[i]] > counts[fqdns[j]]
+		}
+		return fqdns[i] < fqdns[j]
+	})
+
+	printBanner("DISTRIBUTION")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, f := range fqdns {
+		bar := 0
+		if most > 0 {
+			bar = counts[f] * distributionBarWidth / most
+		}
+		if bar == 0 && counts[f] > 0 {
+			// a service with callers always has a bar
+			bar = 1
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d callers\n", r.anonymizer.fqdn(f), strings.Repeat("#", bar), counts[f])
+	}
+	w.Flush()
+}
@@ -0,0 +1,287 @@
+package corednsrunner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const dnsPort = 53
+
+// dnsEgressNetPols builds a NetworkPolicy allowing DNS egress to the CoreDNS pods
+// for every calling workload whose pods are egress restricted by existing
+// NetworkPolicies which don't already allow UDP port 53 to the CoreDNS pods
+// Callers without any egress NetworkPolicy can already reach CoreDNS
+func (r *Runner) dnsEgressNetPols() ([]*networkingv1.NetworkPolicy, error) {
+	if len(r.coreDNSPods.Items) == 0 {
+		return nil, nil
+	}
+	dnsPod := r.coreDNSPods.Items[0]
+
+	dnsSelector, dnsPorts, err := r.dnsServiceSelectorPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	npsByNamespace := map[string][]networkingv1.NetworkPolicy{}
+	seen := map[string]bool{}
+	nps := []*networkingv1.NetworkPolicy{}
+
+	for _, c := range r.connections() {
+		if c.FromPod == "" {
+			continue
+		}
+
+		w, err := r.podOwner(c.FromPod, c.FromNamespace)
+		if err != nil {
+			return nil, err
+		}
+		key := w.Namespace + "/" + w.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		fromPod, err := r.clientset.CoreV1().Pods(c.FromNamespace).Get(ctx, c.FromPod, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// e.g., it was deleted after it queried CoreDNS
+			log.Warnf("pod %s in ns %s not found, skipping its DNS egress", c.FromPod, c.FromNamespace)
+			r.addWarning(WarningAPIError, "couldn't check the DNS egress of the pod %s in ns %s: %v", c.FromPod, c.FromNamespace, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := npsByNamespace[c.FromNamespace]; !ok {
+			npList, err := r.clientset.NetworkingV1().NetworkPolicies(c.FromNamespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			npsByNamespace[c.FromNamespace] = npList.Items
+		}
+
+		restricted, allowed := r.dnsEgressAllowed(fromPod, npsByNamespace[c.FromNamespace], &dnsPod)
+		if !restricted || allowed {
+			continue
+		}
+		log.Debugf("%s in ns %s is egress restricted without DNS egress to CoreDNS", w, c.FromNamespace)
+
+		l := r.withoutIgnoredLabels(fromPod.GetLabels())
+
+		nps = append(nps, r.anonymizer.networkPolicy(&networkingv1.NetworkPolicy{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetworkPolicy",
+				APIVersion: r.policyAPIVersion,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-dns-egress", w.Name),
+				Namespace: c.FromNamespace,
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: l,
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{
+						To: []networkingv1.NetworkPolicyPeer{
+							{
+								NamespaceSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{v1.LabelMetadataName: r.corednsNamespace},
+								},
+								PodSelector: dnsSelector,
+							},
+						},
+						Ports: dnsPorts,
+					},
+				},
+			},
+		}))
+	}
+
+	sort.Slice(nps, func(i, j int) bool {
+		if nps[i].Namespace != nps[j].Namespace {
+			return nps[i].Namespace < nps[j].Namespace
+		}
+		return nps[i].Name < nps[j].Name
+	})
+
+	return nps, nil
+}
+
+// dnsServiceSelectorPorts returns the pod selector and the (target) ports
+// of the DNS service (dnsServiceName in the CoreDNS namespace)
+// If the service doesn't exist, it warns and falls back to the CoreDNS selector
+// and UDP/TCP port 53
+func (r *Runner) dnsServiceSelectorPorts() (*metav1.LabelSelector, []networkingv1.NetworkPolicyPort, error) {
+	svc, err := r.clientset.CoreV1().Services(r.corednsNamespace).Get(context.Background(), r.dnsServiceName, metav1.GetOptions{})
+	if err == nil && len(svc.Spec.Selector) > 0 {
+		return &metav1.LabelSelector{MatchLabels: svc.Spec.Selector}, servicePolicyPorts(*svc), nil
+	}
+
+	if err != nil {
+		r.warnf(WarningDNSEgress, "couldn't get the DNS service %s/%s (use --dns-service-name to point to it): %v", r.corednsNamespace, r.dnsServiceName, err)
+	} else {
+		r.warnf(WarningDNSEgress, "DNS service %s/%s has no selector", r.corednsNamespace, r.dnsServiceName)
+	}
+	log.Warnf("using the CoreDNS selector %s and port %d for the DNS egress rule", r.corednsSelector, dnsPort)
+
+	dnsSelector, err := metav1.ParseToLabelSelector(r.corednsSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	udp := v1.ProtocolUDP
+	tcp := v1.ProtocolTCP
+	port := intstr.FromInt(dnsPort)
+	return dnsSelector, []networkingv1.NetworkPolicyPort{
+		{Protocol: &udp, Port: &port},
+		{Protocol: &tcp, Port: &port},
+	}, nil
+}
+
+// dnsEgressAllowed checks the NetworkPolicies selecting the pod
+// restricted is true if any of them restricts egress
+// allowed is true if any of them allows UDP port 53 to the CoreDNS pod
+func (r *Runner) dnsEgressAllowed(pod *v1.Pod, nps []networkingv1.NetworkPolicy, dnsPod *v1.Pod) (restricted bool, allowed bool) {
+	for _, np := range nps {
+		if !hasPolicyType(np, networkingv1.PolicyTypeEgress) {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+		if err != nil {
+			log.Errorf("couldn't parse pod selector of NetworkPolicy %s: %v", np.Name, err)
+			r.addWarning(WarningAPIError, "couldn't parse pod selector of NetworkPolicy %s: %v", np.Name, err)
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.GetLabels())) {
+			continue
+		}
+
+		restricted = true
+		for _, rule := range np.Spec.Egress {
+			if r.peersInclude(rule.To, np.Namespace, dnsPod) && portsInclude(rule.Ports, dnsPod) {
+				return restricted, true
+			}
+		}
+	}
+	return restricted, false
+}
+
+// peersInclude returns true if the (egress or ingress) peers of a NetworkPolicy
+// in the namespace policyNamespace include the pod
+func (r *Runner) peersInclude(peers []networkingv1.NetworkPolicyPeer, policyNamespace string, pod *v1.Pod) bool {
+	if len(peers) == 0 {
+		// no peers means anywhere
+		return true
+	}
+
+	for _, p := range peers {
+		if p.IPBlock != nil {
+			if ipBlockIncludes(p.IPBlock, pod.Status.PodIP) {
+				return true
+			}
+			continue
+		}
+
+		if p.NamespaceSelector == nil {
+			if policyNamespace != pod.Namespace {
+				continue
+			}
+		} else if !selectorMatches(p.NamespaceSelector, r.namespaceLabels(pod.Namespace)) {
+			continue
+		}
+
+		if p.PodSelector == nil || selectorMatches(p.PodSelector, pod.GetLabels()) {
+			return true
+		}
+	}
+	return false
+}
+
+// portsInclude returns true if the ports include UDP port 53 of the pod
+func portsInclude(ports []networkingv1.NetworkPolicyPort, pod *v1.Pod) bool {
+	if len(ports) == 0 {
+		// no ports means all ports
+		return true
+	}
+
+	for _, p := range ports {
+		if p.Protocol == nil || *p.Protocol != v1.ProtocolUDP {
+			continue
+		}
+		if p.Port == nil {
+			return true
+		}
+		if p.Port.Type == intstr.String {
+			if containerPort(pod, p.Port.StrVal) == dnsPort {
+				return true
+			}
+			continue
+		}
+		end := p.Port.IntVal
+		if p.EndPort != nil {
+			end = *p.EndPort
+		}
+		if p.Port.IntVal <= dnsPort && dnsPort <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// containerPort returns the number of the named UDP container port of the pod
+func containerPort(pod *v1.Pod, name string) int32 {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == name && p.Protocol == v1.ProtocolUDP {
+				return p.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+func ipBlockIncludes(b *networkingv1.IPBlock, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	_, cidr, err := net.ParseCIDR(b.CIDR)
+	if err != nil || !cidr.Contains(parsed) {
+		return false
+	}
+	for _, except := range b.Except {
+		if _, e, err := net.ParseCIDR(except); err == nil && e.Contains(parsed) {
+			return false
+		}
+	}
+	return true
+}
+
+func selectorMatches(s *metav1.LabelSelector, l map[string]string) bool {
+	selector, err := metav1.LabelSelectorAsSelector(s)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(l))
+}
+
+// namespaceLabels returns the labels of a namespace from allNamespaces
+func (r *Runner) namespaceLabels(namespace string) map[string]string {
+	for _, n := range r.allNamespaces.Items {
+		if n.Name == namespace {
+			return n.GetLabels()
+		}
+	}
+	return nil
+}
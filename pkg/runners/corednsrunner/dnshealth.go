@@ -0,0 +1,170 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// largeResponseSize is the maximum size of a DNS response over UDP without EDNS0
+// Larger responses are truncated and the client retries over TCP
+const largeResponseSize = 512
+
+// largeResponses are the responses over largeResponseSize for a FQDN
+type largeResponses struct {
+	count   int
+	maxSize int
+	tcp     int
+}
+
+// queryRcode extracts the query name and the response code from a CoreDNS query log
+// e.g., for the log
+// [INFO] 10.42.2.90:59003 - 9687 "AAAA IN user-db.sock-shop.svc.cluster.local. udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s
+// it returns `user-db.sock-shop.svc.cluster.local.` and `NOERROR`
+func queryRcode(rawText string, logLevelMarker string) (string, string, bool) {
+	if !strings.HasPrefix(rawText, logLevelMarker) {
+		return "", "", false
+	}
+
+	qs := strings.Index(rawText, "\"")
+	qe := strings.LastIndex(rawText, "\"")
+	if qs < 0 || qe <= qs {
+		return "", "", false
+	}
+
+	// "AAAA IN user-db.sock-shop.svc.cluster.local. udp 53 false 512"
+	query := strings.Fields(rawText[qs+1 : qe])
+	// NOERROR qr,aa,rd 146 0.000428325s
+	response := strings.Fields(rawText[qe+1:])
+	if len(query) < 3 || len(response) < 1 {
+		return "", "", false
+	}
+
+	qname := query[2]
+	if !strings.HasSuffix(qname, ".") {
+		qname = qname + "."
+	}
+
+	return qname, response[0], true
+}
+
+// queryIDTransport extracts the query ID and the transport from a CoreDNS query log
+// e.g., `9687` and `udp` for the log in queryRcode
+func queryIDTransport(rawText string) (string, string, bool) {
+	qs := strings.Index(rawText, "\"")
+	qe := strings.LastIndex(rawText, "\"")
+	if qs < 0 || qe <= qs {
+		return "", "", false
+	}
+
+	// [INFO] 10.42.2.90:59003 - 9687
+	prefix := strings.Fields(rawText[:qs])
+	// "AAAA IN user-db.sock-shop.svc.cluster.local. udp 53 false 512"
+	query := strings.Fields(rawText[qs+1 : qe])
+	if len(prefix) < 1 || len(query) < 4 {
+		return "", "", false
+	}
+
+	return prefix[len(prefix)-1], query[3], true
+}
+
+// responseSize extracts the size of the response in bytes from a CoreDNS query log
+// e.g., 146 for the log in queryRcode. It returns 0 if the size isn't logged
+func responseSize(rawText string) int {
+	qe := strings.LastIndex(rawText, "\"")
+	if qe < 0 {
+		return 0
+	}
+
+	// NOERROR qr,aa,rd 146 0.000428325s
+	response := strings.Fields(rawText[qe+1:])
+	if len(response) < 3 {
+		return 0
+	}
+	size, err := strconv.Atoi(response[2])
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// countRcode counts the response code of the log
+// if the query is for one of the toPod service FQDNs
+// Queries for FQDNs expanded using search domains
+// e.g., user-db.sock-shop.svc.cluster.local.sock-shop.svc.cluster.local. are counted too
+func (r *Runner) countRcode(rawText string) {
+	qname, rcode, ok := queryRcode(rawText, r.logFilter.LogLevelMarker)
+	if !ok {
+		return
+	}
+
+	for _, f := range r.toPodServiceFQDNs {
+		if qname == f || strings.HasPrefix(qname, f) {
+			r.rcodes[rcode]++
+			return
+		}
+	}
+}
+
+// printDNSHealth prints how many responses of every response code
+// were seen for the toPod service FQDNs
+func printDNSHealth(rcodes map[string]int) {
+	printBanner("DNS HEALTH")
+
+	if len(rcodes) == 0 {
+		fmt.Fprintln(os.Stderr, "no queries found for the pod's services")
+		return
+	}
+
+	keys := []string{}
+	for k := range rcodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("%s: %d\n", k, rcodes[k])
+	}
+}
+
+// addLargeResponse records a response over largeResponseSize
+func (r *Runner) addLargeResponse(c *ConnectionLog) {
+	l, ok := r.largeResponses[c.ToHostname]
+	if !ok {
+		l = &largeResponses{}
+		r.largeResponses[c.ToHostname] = l
+	}
+	l.count++
+	if c.ResponseSize > l.maxSize {
+		l.maxSize = c.ResponseSize
+	}
+	if c.Transport == "tcp" {
+		l.tcp++
+	}
+}
+
+// printLargeResponses prints the FQDNs with responses over largeResponseSize
+// These are usually headless services with many pods, whose responses
+// don't fit in a UDP message and are retried over TCP
+func (r *Runner) printLargeResponses() {
+	printBanner("LARGE DNS RESPONSES")
+
+	if len(r.largeResponses) == 0 {
+		fmt.Fprintf(os.Stderr, "no responses over %d bytes\n", largeResponseSize)
+		return
+	}
+
+	fqdns := []string{}
+	for f := range r.largeResponses {
+		fqdns = append(fqdns, f)
+	}
+	sort.Strings(fqdns)
+
+	for _, f := range fqdns {
+		l := r.largeResponses[f]
+		fmt.Printf("svc: %s, responses over %d bytes: %d (max %d bytes, %d over tcp)\n", r.anonymizer.fqdn(f), largeResponseSize, l.count, l.maxSize, l.tcp)
+	}
+	fmt.Fprintln(os.Stderr, "note: responses over 512 bytes are truncated over UDP (without EDNS0) and retried over TCP, which usually means a headless service with many pods")
+}
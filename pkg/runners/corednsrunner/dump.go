@@ -0,0 +1,71 @@
+package corednsrunner
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ResourceBundle is everything kico fetched for analyzing a pod
+// e.g., for reproducing an issue without access to the cluster
+type ResourceBundle struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Context is the kubeconfig context the resources are from
+	Context     string         `json:"context,omitempty"`
+	TargetPod   *v1.Pod        `json:"targetPod"`
+	Services    []v1.Service   `json:"services"`
+	Namespaces  []v1.Namespace `json:"namespaces"`
+	Endpoints   []v1.Endpoints `json:"endpoints"`
+	CoreDNSPods []v1.Pod       `json:"corednsPods"`
+	// CoreDNSLogs are the raw logs per CoreDNS pod (set with DumpLogs)
+	CoreDNSLogs map[string][]string `json:"corednsLogs,omitempty"`
+}
+
+// writeResourceBundle writes the ResourceBundle as YAML to path
+func (r *Runner) writeResourceBundle(path string) error {
+	b := ResourceBundle{
+		APIVersion:  ResultAPIVersion,
+		Kind:        "ResourceBundle",
+		Context:     r.kubeContext,
+		TargetPod:   r.toPod,
+		Services:    r.toPodServices,
+		Namespaces:  r.allNamespaces.Items,
+		Endpoints:   []v1.Endpoints{},
+		CoreDNSPods: r.coreDNSPods.Items,
+		CoreDNSLogs: r.rawLogs,
+	}
+	if b.Services == nil {
+		b.Services = []v1.Service{}
+	}
+
+	namespaces := []string{}
+	for ns := range r.allEndpoints {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		b.Endpoints = append(b.Endpoints, r.allEndpoints[ns].Items...)
+	}
+
+	j, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	v := map[string]interface{}{}
+	if err := json.Unmarshal(j, &v); err != nil {
+		return err
+	}
+	y, err := encodeYAML(&v)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, y, 0644); err != nil {
+		return err
+	}
+
+	log.Infof("wrote the resources to %s\n", path)
+	return nil
+}
@@ -0,0 +1,69 @@
+package corednsrunner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// findFromPod gets the pod given as `<pod-name>` (in the namespace of the toPod)
+// or `<namespace>/<pod-name>` and indexes its IPs so that its queries are resolved
+// even if the pod isn't behind any service
+func (r *Runner) findFromPod(fromPodName string) error {
+	namespace, name, found := strings.Cut(fromPodName, "/")
+	if !found {
+		namespace, name = r.toPod.Namespace, fromPodName
+	}
+
+	pod, err := r.clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't get the --from pod: %w", err)
+	}
+	r.fromPod = pod
+
+	ips := []string{pod.Status.PodIP}
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	for _, ip := range ips {
+		if ip == "" {
+			continue
+		}
+		if _, ok := r.podsByIP[normalizeIP(ip)]; !ok {
+			r.podsByIP[normalizeIP(ip)] = &Mapping{podname: pod.Name, namespace: pod.Namespace}
+		}
+	}
+	return nil
+}
+
+// printFromPodConnections prints whether the fromPod queried the services of the toPod
+// along with the number of queries per service as the evidence
+func (r *Runner) printFromPodConnections() error {
+	fromPod := fmt.Sprintf("pod %s (ns %s)", r.anonymizer.Pod(r.fromPod.Name), r.anonymizer.Namespace(r.fromPod.Namespace))
+	toPod := fmt.Sprintf("pod %s (ns %s)", r.anonymizer.Pod(r.toPod.Name), r.anonymizer.Namespace(r.toPod.Namespace))
+
+	evidence := []string{}
+	for hostname, mappings := range r.hostnamePodMapping {
+		for _, m := range mappings {
+			if m.podname != r.fromPod.Name || m.namespace != r.fromPod.Namespace {
+				continue
+			}
+			evidence = append(evidence, fmt.Sprintf("svc: %s, queries: %d (first query id: %s, transport: %s)", r.anonymizer.fqdn(hostname), m.count, m.queryID, m.transport))
+		}
+	}
+	sort.Strings(evidence)
+
+	if len(evidence) == 0 {
+		fmt.Printf("no: %s didn't query the services of %s\n", fromPod, toPod)
+		return nil
+	}
+
+	fmt.Printf("yes: %s connected to %s\n", fromPod, toPod)
+	for _, e := range evidence {
+		fmt.Println(e)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package corednsrunner
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// indexNodes maps the node IPs to the node names and the node names
+// to the host network pods (`<namespace>/<pod-name>`) running on them
+// A query from a node IP comes from the node or from one of its host network pods
+func indexNodes(clientset kubernetes.Interface) (map[string]string, map[string][]string, error) {
+	ctx := context.Background()
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodesByIP := map[string]string{}
+	for _, n := range nodeList.Items {
+		for _, a := range n.Status.Addresses {
+			if a.Type == v1.NodeInternalIP || a.Type == v1.NodeExternalIP {
+				nodesByIP[normalizeIP(a.Address)] = n.Name
+			}
+		}
+	}
+
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.hostNetwork=true",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostNetworkPods := map[string][]string{}
+	for _, p := range podList.Items {
+		hostNetworkPods[p.Spec.NodeName] = append(hostNetworkPods[p.Spec.NodeName], p.Namespace+"/"+p.Name)
+	}
+
+	return nodesByIP, hostNetworkPods, nil
+}
@@ -0,0 +1,84 @@
+package corednsrunner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// selectPeers asks the user whether to include every peer of the suggested
+// NetworkPolicy and remembers the excluded ones so that buildNetPol leaves them out
+func (r *Runner) selectPeers(in io.Reader) error {
+	r.excludedPeers = nil
+	n, sources, err := r.buildNetPol()
+	if err != nil {
+		return err
+	}
+	if len(n.Spec.Ingress) == 0 || len(n.Spec.Ingress[0].From) == 0 {
+		return nil
+	}
+
+	printBanner("SELECT PEERS")
+	reader := bufio.NewReader(in)
+	excluded := []string{}
+	for i, peer := range n.Spec.Ingress[0].From {
+		fmt.Fprintf(os.Stderr, "peer: pods(%s)\n", metav1.FormatLabelSelector(peer.PodSelector))
+		if i < len(sources) {
+			fmt.Fprintf(os.Stderr, "  %s\n", sources[i])
+		}
+
+		include, err := confirm(reader, "include the peer in the NetworkPolicy? [Y/n] ")
+		if err != nil {
+			return err
+		}
+		if !include {
+			excluded = append(excluded, metav1.FormatLabelSelector(peer.PodSelector))
+		}
+	}
+
+	r.excludedPeers = excluded
+	return nil
+}
+
+// confirm prompts on stderr and reads the answer
+// An empty answer means yes
+func confirm(reader *bufio.Reader, prompt string) (bool, error) {
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		answer, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			return false, fmt.Errorf("couldn't read the answer: %w", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// withoutExcludedPeers removes the peers the user excluded in selectPeers
+// keeping sources[i] as the rationale behind peers[i]
+func (r *Runner) withoutExcludedPeers(peers []networkingv1.NetworkPolicyPeer, sources []*peerSource) ([]networkingv1.NetworkPolicyPeer, []*peerSource) {
+	if len(r.excludedPeers) == 0 {
+		return peers, sources
+	}
+
+	selectedPeers := []networkingv1.NetworkPolicyPeer{}
+	selectedSources := []*peerSource{}
+	for i, peer := range peers {
+		if contains(r.excludedPeers, metav1.FormatLabelSelector(peer.PodSelector)) {
+			continue
+		}
+		selectedPeers = append(selectedPeers, peer)
+		selectedSources = append(selectedSources, sources[i])
+	}
+	return selectedPeers, selectedSources
+}
@@ -0,0 +1,65 @@
+package corednsrunner
+
+import (
+	"net"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// ipBlockRuleComment separates the ipBlock peers from the pod peers in the suggested NetworkPolicy
+const ipBlockRuleComment = "callers which couldn't be resolved to pods e.g., from outside the cluster or from host network pods"
+
+// ipCIDR returns the CIDR with the prefix length containing the IP
+// e.g., `203.0.113.0/24` for `203.0.113.7` and 24
+// IPv6 IPs are always returned as /128
+func ipCIDR(ip string, prefixLength int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		if prefixLength <= 0 || prefixLength > 32 {
+			prefixLength = 32
+		}
+		mask := net.CIDRMask(prefixLength, 32)
+		return (&net.IPNet{IP: v4.Mask(mask), Mask: mask}).String()
+	}
+	return (&net.IPNet{IP: parsed, Mask: net.CIDRMask(128, 128)}).String()
+}
+
+// ipBlockPeers returns an ipBlock peer per CIDR of the unresolved callers
+// i.e., a /32 (or /128) per IP or, with ipBlockPrefixLength, the IPv4 CIDRs summarizing them
+func (r *Runner) ipBlockPeers() []networkingv1.NetworkPolicyPeer {
+	cidrs := []string{}
+	for _, u := range r.unresolved {
+		cidr := ipCIDR(u.IP, r.ipBlockPrefixLength)
+		if cidr != "" && !contains(cidrs, cidr) {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	sort.Strings(cidrs)
+
+	peers := []networkingv1.NetworkPolicyPeer{}
+	for _, c := range cidrs {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: c}})
+	}
+	return peers
+}
+
+// annotateIPBlockRule comments the ingress rule with the ipBlock peers
+func annotateIPBlockRule(n *yaml.Node) {
+	ingress := mappingValue(mappingValue(n, "spec"), "ingress")
+	if ingress == nil {
+		return
+	}
+
+	for _, rule := range ingress.Content {
+		from := mappingValue(rule, "from")
+		if from != nil && len(from.Content) > 0 && mappingValue(from.Content[0], "ipBlock") != nil {
+			rule.HeadComment = ipBlockRuleComment
+		}
+	}
+}
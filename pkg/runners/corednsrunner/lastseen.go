@@ -0,0 +1,63 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// splitLogTimestamp splits the timestamp prepended to a log line with PodLogOptions.Timestamps
+// e.g., `2023-01-02T15:04:05.123456789Z [INFO] 10.42.2.90:59003 - ...`
+// The line is returned as it is with a zero time if it doesn't start with a timestamp
+func splitLogTimestamp(line string) (time.Time, string) {
+	ts, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return time.Time{}, line
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, line
+	}
+	return t, rest
+}
+
+// addQueryTime keeps the time of the latest query from the pod
+func (m *Mapping) addQueryTime(t time.Time) {
+	if t.After(m.lastQueried) {
+		m.lastQueried = t
+	}
+}
+
+// LastQueried returns when the latest query from the caller pod was logged (nil if unknown)
+func (m *Mapping) LastQueried() *time.Time {
+	if m.lastQueried.IsZero() {
+		return nil
+	}
+	t := m.lastQueried
+	return &t
+}
+
+// lastSeenAge formats how long ago a caller last queried the toPod
+// like the AGE column of kubectl e.g., `2m ago` (`-` if unknown)
+func lastSeenAge(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return duration.HumanDuration(time.Since(*t)) + " ago"
+}
+
+// printWideConnections prints the connections with their query ID, transport
+// and last seen age once all the logs are processed (the age isn't known before)
+func (r *Runner) printWideConnections() {
+	for _, c := range r.connections() {
+		pod := r.colorize(colorPod, r.anonymizer.Pod(c.FromPod))
+		ns := r.colorize(colorNamespace, r.anonymizer.Namespace(c.FromNamespace))
+		svc := r.colorize(colorService, r.anonymizer.fqdn(c.ToFQDN))
+		fmt.Printf("pod: %s, ns: %s via svc: %s%s (query id: %s, transport: %s, last seen: %s)\n", pod, ns, svc, relationSuffix(c.Relation), c.QueryID, c.Transport, lastSeenAge(c.LastSeen))
+	}
+	for _, u := range r.unresolved {
+		fmt.Printf("ip: %s %s via svc: %s\n", u.IP, r.describeUnresolved(u), r.anonymizer.fqdn(u.ToFQDN))
+	}
+}
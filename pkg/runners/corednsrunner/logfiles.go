@@ -0,0 +1,118 @@
+package corednsrunner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LogBackendFile reads the CoreDNS logs from files e.g., exported from a log aggregator
+const LogBackendFile = "file"
+
+// readsPodLogs returns true if the CoreDNS logs are read from the CoreDNS pods
+// i.e., neither from Loki nor from log files
+func (r *Runner) readsPodLogs() bool {
+	return r.loki == nil && len(r.logFiles) == 0
+}
+
+// openLogFiles calls fn with every log file in the paths in order
+// A `.gz` file is decompressed and every regular file in a `.tar.gz` (or `.tgz`) archive
+// is read in the archive order, decompressed too if it ends with `.gz`
+func openLogFiles(paths []string, fn func(name string, rd io.Reader) error) error {
+	for _, p := range paths {
+		if err := openLogFile(p, fn); err != nil {
+			return fmt.Errorf("couldn't read the log file %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// openLogFile calls fn with the log file or the log files in the archive (see openLogFiles)
+func openLogFile(path string, fn func(name string, rd io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !strings.HasSuffix(path, ".gz") && !strings.HasSuffix(path, ".tgz") {
+		return fn(path, f)
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	if !strings.HasSuffix(path, ".tar.gz") && !strings.HasSuffix(path, ".tgz") {
+		return fn(path, gz)
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path + ":" + h.Name
+		if !strings.HasSuffix(h.Name, ".gz") {
+			if err := fn(name, tr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		member, err := gzip.NewReader(tr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Name, err)
+		}
+		err = fn(name, member)
+		member.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseFileConnectionLogs parses the CoreDNS logs in the log files
+// the same way as the logs of the CoreDNS pods
+// The lines can have the timestamps of `kubectl logs --timestamps` (see splitLogTimestamp)
+func (r *Runner) parseFileConnectionLogs() ([]*ConnectionLog, error) {
+	connLogList := []*ConnectionLog{}
+	err := openLogFiles(r.logFiles, func(name string, rd io.Reader) error {
+		scanner := r.newLogScanner(rd)
+		for scanner.Scan() {
+			logTime, t := splitLogTimestamp(scanner.Text())
+			r.linesScanned[name]++
+			if r.rawLogs != nil {
+				r.rawLogs[name] = append(r.rawLogs[name], t)
+			}
+			r.countRcode(t)
+
+			c, success, err := r.logParser.Parse(t)
+			if err != nil {
+				return err
+			}
+			if success {
+				c.Time = logTime
+				connLogList = append(connLogList, c)
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connLogList, nil
+}
@@ -0,0 +1,174 @@
+package corednsrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LogBackendPods reads the logs of the CoreDNS pods (default)
+	LogBackendPods = "pods"
+	// LogBackendLoki queries the CoreDNS logs from Loki
+	LogBackendLoki = "loki"
+
+	// DefaultLokiSince is how far back the CoreDNS logs are queried from Loki
+	DefaultLokiSince = 24 * time.Hour
+
+	// lokiLimit is the maximum number of lines fetched per request
+	// (the default `max_entries_limit_per_query` of Loki)
+	lokiLimit   = 5000
+	lokiTimeout = 60 * time.Second
+)
+
+// Loki queries the CoreDNS logs from a Loki instance
+// e.g., on clusters where the pod logs are rotated too often to analyze the history
+type Loki struct {
+	// URL is the base URL of Loki e.g., http://loki.monitoring:3100
+	URL string
+	// Query is the LogQL (stream selector and filters) selecting the CoreDNS logs
+	Query string
+	// Since is the time window of the query ending now
+	Since  time.Duration
+	client *http.Client
+}
+
+// NewLoki creates a Loki querying the logs selected by query in the window since
+func NewLoki(lokiURL, query string, since time.Duration) *Loki {
+	return &Loki{
+		URL:    strings.TrimSuffix(lokiURL, "/"),
+		Query:  query,
+		Since:  since,
+		client: &http.Client{Timeout: lokiTimeout},
+	}
+}
+
+// DefaultLokiQuery selects the logs of the CoreDNS containers in the namespace
+// using the labels set by promtail's Kubernetes service discovery
+func DefaultLokiQuery(corednsNamespace string) string {
+	return fmt.Sprintf(`{namespace=%q, container="coredns"}`, corednsNamespace)
+}
+
+// lokiQueryRangeResponse is the response of `/loki/api/v1/query_range` for a log query
+type lokiQueryRangeResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			// Values are `[<unix epoch in nanoseconds>, <log line>]`
+			Values [][2]string `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// lokiEntry is a log line with its timestamp
+type lokiEntry struct {
+	ts   int64
+	line string
+}
+
+// logLines returns the log lines in the time window oldest first
+// Loki returns at most lokiLimit lines per request, so the window is paged through
+func (l *Loki) logLines() ([]string, error) {
+	end := time.Now().UnixNano()
+	start := end - l.Since.Nanoseconds()
+
+	lines := []string{}
+	for {
+		entries, err := l.queryRange(start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			lines = append(lines, e.line)
+		}
+		if len(entries) < lokiLimit {
+			break
+		}
+
+		// the next page starts after the last line of this page
+		// lines with the same timestamp as the last line which didn't fit in this page are lost
+		start = entries[len(entries)-1].ts + 1
+		log.Debugf("fetched %d lines from loki, fetching the next page", len(lines))
+	}
+
+	log.Debugf("fetched %d lines from loki", len(lines))
+	return lines, nil
+}
+
+// queryRange fetches a page of log lines in [start, end] oldest first
+func (l *Loki) queryRange(start, end int64) ([]lokiEntry, error) {
+	params := url.Values{}
+	params.Set("query", l.Query)
+	params.Set("start", strconv.FormatInt(start, 10))
+	params.Set("end", strconv.FormatInt(end, 10))
+	params.Set("limit", strconv.Itoa(lokiLimit))
+	params.Set("direction", "forward")
+
+	resp, err := l.client.Get(l.URL + "/loki/api/v1/query_range?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("loki responded with %s for query %s", resp.Status, l.Query)
+	}
+
+	res := lokiQueryRangeResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("couldn't decode the loki response: %w", err)
+	}
+	if res.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("loki returned `%s` instead of log lines, is %s a log query?", res.Data.ResultType, l.Query)
+	}
+
+	// the lines are sorted per stream, merge the streams
+	entries := []lokiEntry{}
+	for _, s := range res.Data.Result {
+		for _, v := range s.Values {
+			ts, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse the loki timestamp %s: %w", v[0], err)
+			}
+			entries = append(entries, lokiEntry{ts: ts, line: v[1]})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ts < entries[j].ts
+	})
+
+	return entries, nil
+}
+
+// parseLokiConnectionLogs parses the CoreDNS logs queried from Loki
+// the same way as the logs of the CoreDNS pods
+func (r *Runner) parseLokiConnectionLogs() ([]*ConnectionLog, error) {
+	lines, err := r.loki.logLines()
+	if err != nil {
+		return nil, err
+	}
+	r.linesScanned[LogBackendLoki] += len(lines)
+	if r.rawLogs != nil {
+		r.rawLogs[LogBackendLoki] = append(r.rawLogs[LogBackendLoki], lines...)
+	}
+
+	connLogList := []*ConnectionLog{}
+	for _, t := range joinContinuationLines(lines, r.logFilter.ContinuationPrefix) {
+		r.countRcode(t)
+
+		c, success, err := r.logParser.Parse(t)
+		if err != nil {
+			return nil, err
+		}
+		if success {
+			connLogList = append(connLogList, c)
+		}
+	}
+	return connLogList, nil
+}
@@ -0,0 +1,70 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// OutputMarkdown is the connections as a GitHub-flavored Markdown table
+// e.g., for pasting into PRs and docs
+const OutputMarkdown = "markdown"
+
+// writeMarkdown writes the connections of the toPod as a Markdown table
+// followed by the suggested NetworkPolicy (if any) in a YAML code block
+func (r *Runner) writeMarkdown(w io.Writer) error {
+	title := fmt.Sprintf("### Incoming connections of pod `%s` (ns `%s`)", r.anonymizer.Pod(r.toPod.Name), r.anonymizer.Namespace(r.toPod.Namespace))
+	if r.kubeContext != "" {
+		title = fmt.Sprintf("%s in context `%s`", title, r.kubeContext)
+	}
+	fmt.Fprintf(w, "%s\n\n", title)
+
+	connections := r.connections()
+	if len(connections) == 0 && len(r.unresolved) == 0 {
+		fmt.Fprintf(w, "No incoming connections found.\n")
+	} else {
+		fmt.Fprintln(w, "| Source pod | Source namespace | Service | Relation |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- |")
+		for _, c := range connections {
+			c = r.anonymizer.connection(c)
+			fmt.Fprintf(w, "| %s | %s | %s | %s |\n", markdownCell(c.FromPod), markdownCell(c.FromNamespace), markdownCell(c.ToFQDN), c.Relation)
+		}
+
+		unresolved := append([]Unresolved{}, r.unresolved...)
+		sort.Slice(unresolved, func(i, j int) bool {
+			if unresolved[i].ToFQDN != unresolved[j].ToFQDN {
+				return unresolved[i].ToFQDN < unresolved[j].ToFQDN
+			}
+			return unresolved[i].IP < unresolved[j].IP
+		})
+		for _, u := range unresolved {
+			fmt.Fprintf(w, "| %s | - | %s | - |\n", markdownCell(u.IP+" "+r.describeUnresolved(u)), markdownCell(r.anonymizer.fqdn(u.ToFQDN)))
+		}
+	}
+
+	// with a PolicyList, the NetworkPolicy is printed as a part of the list
+	if !r.suggestNetworkPolicy || r.policyList != nil {
+		return nil
+	}
+
+	tooMany, err := r.tooManyPeers()
+	if err != nil {
+		return err
+	}
+	if tooMany {
+		return nil
+	}
+
+	b, err := r.netPolYAML()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n#### Suggested NetworkPolicy\n\n```yaml\n%s```\n", string(b))
+	return nil
+}
+
+// markdownCell escapes the characters which would break a Markdown table cell
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
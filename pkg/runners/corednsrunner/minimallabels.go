@@ -0,0 +1,128 @@
+package corednsrunner
+
+import (
+	"context"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultLabelKeyPriority is the order in which the label keys are tried
+// for the minimal labels of a peer (see InitConfig.MinimalPeerLabels)
+var DefaultLabelKeyPriority = []string{
+	"app.kubernetes.io/name",
+	"app",
+	"name",
+	"k8s-app",
+	"app.kubernetes.io/instance",
+	"app.kubernetes.io/component",
+	"component",
+}
+
+// minimalLabels returns the fewest labels of the pod which select only the pods
+// of its workload in its namespace. The label keys are tried in labelKeyPriority
+// order, then alphabetically: every key alone first, then the first 2, 3... keys
+// All the labels are returned if no subset selects only the workload
+func (r *Runner) minimalLabels(pod *v1.Pod, l map[string]string) (map[string]string, error) {
+	owner, err := r.podOwner(pod.Name, pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := r.podsInNamespace(pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := r.labelKeysByPriority(l)
+	candidates := [][]string{}
+	for _, k := range keys {
+		candidates = append(candidates, []string{k})
+	}
+	for n := 2; n < len(keys); n++ {
+		candidates = append(candidates, keys[:n])
+	}
+
+	for _, c := range candidates {
+		selector := map[string]string{}
+		for _, k := range c {
+			selector[k] = l[k]
+		}
+		only, err := r.selectsOnlyWorkload(selector, owner, pods)
+		if err != nil {
+			return nil, err
+		}
+		if only {
+			return selector, nil
+		}
+	}
+	return l, nil
+}
+
+// labelKeysByPriority sorts the label keys in labelKeyPriority order
+// followed by the other keys alphabetically
+func (r *Runner) labelKeysByPriority(l map[string]string) []string {
+	keys := []string{}
+	for _, k := range r.labelKeyPriority {
+		if _, ok := l[k]; ok && !contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+
+	rest := []string{}
+	for k := range l {
+		if !contains(keys, k) {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// selectsOnlyWorkload checks whether every pod matching the selector is owned by the workload
+func (r *Runner) selectsOnlyWorkload(selector map[string]string, owner *Workload, pods []v1.Pod) (bool, error) {
+	s := labels.SelectorFromSet(selector)
+	for i := range pods {
+		if !s.Matches(labels.Set(pods[i].Labels)) {
+			continue
+		}
+
+		key := pods[i].Namespace + "/" + pods[i].Name
+		w, ok := r.podOwners[key]
+		if !ok {
+			w = &Workload{Kind: "Pod", Name: pods[i].Name, Namespace: pods[i].Namespace}
+			owners, err := r.ownerChain(&pods[i])
+			if err != nil {
+				// the pod is its own workload like in podOwner
+				log.Warnf("couldn't find the owner of the pod %s in ns %s: %v, using the pod as its workload", pods[i].Name, pods[i].Namespace, err)
+				r.addWarning(WarningAPIError, "couldn't find the owner of the pod %s in ns %s: %v", pods[i].Name, pods[i].Namespace, err)
+			}
+			if len(owners) > 0 {
+				w = &owners[len(owners)-1]
+			}
+			r.podOwners[key] = w
+		}
+		if *w != *owner {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// podsInNamespace lists the pods in the namespace once per run
+func (r *Runner) podsInNamespace(namespace string) ([]v1.Pod, error) {
+	if pods, ok := r.namespacePods[namespace]; ok {
+		return pods, nil
+	}
+
+	podList, err := r.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if r.namespacePods == nil {
+		r.namespacePods = map[string][]v1.Pod{}
+	}
+	r.namespacePods[namespace] = podList.Items
+	return podList.Items, nil
+}
@@ -0,0 +1,87 @@
+package corednsrunner
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// newLogScanner scans the log entries of a CoreDNS pod
+// Lines starting with LogFilter.ContinuationPrefix are joined to the previous line
+// e.g., for a multi-line log format or wrapped long queries
+func (r *Runner) newLogScanner(rd io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(rd)
+	// scanner has a limitation where it can read max 65536 characters
+	// More info and solution: https://stackoverflow.com/a/16615559/6874596
+	if r.logFilter.ContinuationPrefix != "" {
+		scanner.Split(splitLogEntries(r.logFilter.ContinuationPrefix))
+	}
+	return scanner
+}
+
+// splitLogEntries is a bufio.SplitFunc like bufio.ScanLines but a line starting with
+// the prefix continues the previous line. The continuation lines are joined
+// with a space without the prefix
+// When following the logs, an entry is returned once the next line shows up
+// because only the next line tells whether the entry is complete
+func splitLogEntries(prefix string) bufio.SplitFunc {
+	p := []byte(prefix)
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		entry := []byte{}
+		start := 0
+		for {
+			i := bytes.IndexByte(data[start:], '\n')
+			if i < 0 {
+				if !atEOF {
+					// request more data
+					return 0, nil, nil
+				}
+				// the last line without a newline
+				return len(data), joinLogLine(entry, data[start:], start > 0, p), nil
+			}
+
+			entry = joinLogLine(entry, bytes.TrimSuffix(data[start:start+i], []byte("\r")), start > 0, p)
+			next := start + i + 1
+			rest := data[next:]
+			if len(rest) < len(p) && !atEOF && bytes.HasPrefix(p, rest) {
+				// can't tell yet whether the next line continues the entry
+				return 0, nil, nil
+			}
+			if !bytes.HasPrefix(rest, p) {
+				return next, entry, nil
+			}
+			start = next
+		}
+	}
+}
+
+// joinLogLine appends the line to the entry, joining a continuation line with a space
+func joinLogLine(entry, line []byte, continuation bool, prefix []byte) []byte {
+	if !continuation {
+		return append(entry, line...)
+	}
+	return append(append(entry, ' '), bytes.TrimPrefix(line, prefix)...)
+}
+
+// joinContinuationLines joins the lines starting with the prefix to the previous line
+// like splitLogEntries e.g., for the lines queried from Loki
+func joinContinuationLines(lines []string, prefix string) []string {
+	if prefix == "" {
+		return lines
+	}
+
+	joined := []string{}
+	for _, l := range lines {
+		if len(joined) > 0 && strings.HasPrefix(l, prefix) {
+			joined[len(joined)-1] += " " + strings.TrimPrefix(l, prefix)
+			continue
+		}
+		joined = append(joined, l)
+	}
+	return joined
+}
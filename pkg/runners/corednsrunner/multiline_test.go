@@ -0,0 +1,108 @@
+package corednsrunner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWrappedLogEntries(t *testing.T) {
+	const prefix = "    "
+	tests := []struct {
+		name      string
+		logs      string
+		wantFQDNs []string
+		wantPorts []string
+	}{
+		{
+			name: "not wrapped",
+			logs: `[INFO] 10.42.2.90:59003 - 9687 "A IN user-db.sock-shop.svc.cluster.local. udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s
+[INFO] 10.42.2.91:59004 - 9688 "A IN carts.sock-shop.svc.cluster.local. udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s
+`,
+			wantFQDNs: []string{"user-db.sock-shop.svc.cluster.local.", "carts.sock-shop.svc.cluster.local."},
+			wantPorts: []string{"59003", "59004"},
+		},
+		{
+			name: "wrapped after the query name",
+			logs: `[INFO] 10.42.2.90:59003 - 9687 "A IN user-db.sock-shop.svc.cluster.local.
+    udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s
+[INFO] 10.42.2.91:59004 - 9688 "A IN carts.sock-shop.svc.cluster.local. udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s
+`,
+			wantFQDNs: []string{"user-db.sock-shop.svc.cluster.local.", "carts.sock-shop.svc.cluster.local."},
+			wantPorts: []string{"59003", "59004"},
+		},
+		{
+			name: "wrapped over multiple lines",
+			logs: `[INFO] 10.42.2.90:59003 - 9687
+    "A IN user-db.sock-shop.svc.cluster.local.
+    udp 53 false 512"
+    NOERROR qr,aa,rd 146 0.000428325s
+`,
+			wantFQDNs: []string{"user-db.sock-shop.svc.cluster.local."},
+			wantPorts: []string{"59003"},
+		},
+		{
+			name:      "wrapped with CRLF line endings",
+			logs:      "[INFO] 10.42.2.90:59003 - 9687 \"A IN user-db.sock-shop.svc.cluster.local.\r\n    udp 53 false 512\" NOERROR qr,aa,rd 146 0.000428325s\r\n",
+			wantFQDNs: []string{"user-db.sock-shop.svc.cluster.local."},
+			wantPorts: []string{"59003"},
+		},
+		{
+			name: "wrapped last entry without a newline",
+			logs: `[INFO] plugin/reload: Running configuration SHA512 = 591cf328cccc12bc490481273e738df59329c62c0b729d94e8b61db9961c2fa5
+[INFO] 10.42.2.90:59003 - 9687 "A IN user-db.sock-shop.svc.cluster.local.
+    udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s`,
+			wantFQDNs: []string{"user-db.sock-shop.svc.cluster.local."},
+			wantPorts: []string{"59003"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Runner{logFilter: LogFilter{LogLevelMarker: DefaultLogFilter.LogLevelMarker, ContinuationPrefix: prefix}}
+
+			// the entries are the same whether the logs are read at once or
+			// a byte at a time e.g., while following the logs
+			for _, oneByte := range []bool{false, true} {
+				var scanner = r.newLogScanner(strings.NewReader(tt.logs))
+				if oneByte {
+					scanner = r.newLogScanner(iotest.OneByteReader(strings.NewReader(tt.logs)))
+				}
+
+				fqdns, ports := []string{}, []string{}
+				for scanner.Scan() {
+					c, err, ok := parseLogMsg(scanner.Text(), r.logFilter)
+					if err != nil {
+						t.Fatalf("parseLogMsg(%q) error = %v", scanner.Text(), err)
+					}
+					if ok {
+						fqdns = append(fqdns, c.ToHostname)
+						ports = append(ports, c.FromPort)
+					}
+				}
+				if err := scanner.Err(); err != nil {
+					t.Fatalf("scanner error = %v", err)
+				}
+
+				if !reflect.DeepEqual(fqdns, tt.wantFQDNs) {
+					t.Errorf("one byte reads = %v: FQDNs = %v, want %v", oneByte, fqdns, tt.wantFQDNs)
+				}
+				if !reflect.DeepEqual(ports, tt.wantPorts) {
+					t.Errorf("one byte reads = %v: ports = %v, want %v", oneByte, ports, tt.wantPorts)
+				}
+			}
+
+			// the logs from Loki are joined line by line
+			fqdns := []string{}
+			for _, l := range joinContinuationLines(strings.Split(strings.ReplaceAll(tt.logs, "\r", ""), "\n"), prefix) {
+				if c, err, ok := parseLogMsg(l, r.logFilter); err == nil && ok {
+					fqdns = append(fqdns, c.ToHostname)
+				}
+			}
+			if !reflect.DeepEqual(fqdns, tt.wantFQDNs) {
+				t.Errorf("joinContinuationLines: FQDNs = %v, want %v", fqdns, tt.wantFQDNs)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package corednsrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// existingNetPols returns the NetworkPolicies in the toPod namespace
+// which select the toPod
+func (r *Runner) existingNetPols() ([]networkingv1.NetworkPolicy, error) {
+	npList, err := r.clientset.NetworkingV1().NetworkPolicies(r.toPodNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	selecting := []networkingv1.NetworkPolicy{}
+	for _, np := range npList.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+		if err != nil {
+			log.Errorf("couldn't parse pod selector of NetworkPolicy %s: %v", np.Name, err)
+			r.addWarning(WarningAPIError, "couldn't parse pod selector of NetworkPolicy %s: %v", np.Name, err)
+			continue
+		}
+		if selector.Matches(labels.Set(r.toPod.GetLabels())) {
+			selecting = append(selecting, np)
+		}
+	}
+
+	return selecting, nil
+}
+
+// printExistingNetPols prints a summary of the ingress rules
+// of the NetworkPolicies which already select the toPod
+func printExistingNetPols(podname string, nps []networkingv1.NetworkPolicy) {
+	if len(nps) == 0 {
+		return
+	}
+
+	printBanner("EXISTING NetworkPolicies")
+	fmt.Fprintf(os.Stderr, "note: pod %s is already selected by %d NetworkPolicy(s)\n", podname, len(nps))
+	for _, np := range nps {
+		fmt.Fprintf(os.Stderr, "- %s (policyTypes: %s)\n", np.Name, strings.Join(policyTypes(np), ","))
+		if !hasPolicyType(np, networkingv1.PolicyTypeIngress) {
+			continue
+		}
+		if len(np.Spec.Ingress) == 0 {
+			fmt.Fprintln(os.Stderr, "    denies all ingress")
+		}
+		for _, rule := range np.Spec.Ingress {
+			fmt.Fprintf(os.Stderr, "    allows ingress from %s on %s\n", describePeers(rule.From), describePorts(rule.Ports))
+		}
+	}
+}
+
+// policyTypes returns the policy types of a NetworkPolicy
+// If none are set, K8s defaults to Ingress (plus Egress if there are egress rules)
+func policyTypes(np networkingv1.NetworkPolicy) []string {
+	types := []string{}
+	for _, t := range np.Spec.PolicyTypes {
+		types = append(types, string(t))
+	}
+	if len(types) == 0 {
+		types = append(types, string(networkingv1.PolicyTypeIngress))
+		if len(np.Spec.Egress) > 0 {
+			types = append(types, string(networkingv1.PolicyTypeEgress))
+		}
+	}
+	return types
+}
+
+func hasPolicyType(np networkingv1.NetworkPolicy, t networkingv1.PolicyType) bool {
+	for _, pt := range policyTypes(np) {
+		if pt == string(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func describePeers(peers []networkingv1.NetworkPolicyPeer) string {
+	if len(peers) == 0 {
+		return "anywhere"
+	}
+
+	d := []string{}
+	for _, p := range peers {
+		s := []string{}
+		if p.NamespaceSelector != nil {
+			s = append(s, fmt.Sprintf("namespaces(%s)", metav1.FormatLabelSelector(p.NamespaceSelector)))
+		}
+		if p.PodSelector != nil {
+			s = append(s, fmt.Sprintf("pods(%s)", metav1.FormatLabelSelector(p.PodSelector)))
+		}
+		if p.IPBlock != nil {
+			s = append(s, fmt.Sprintf("ipBlock(%s)", p.IPBlock.CIDR))
+		}
+		d = append(d, strings.Join(s, " and "))
+	}
+	return strings.Join(d, ", ")
+}
+
+func describePorts(ports []networkingv1.NetworkPolicyPort) string {
+	if len(ports) == 0 {
+		return "all ports"
+	}
+
+	d := []string{}
+	for _, p := range ports {
+		protocol := "TCP"
+		if p.Protocol != nil {
+			protocol = string(*p.Protocol)
+		}
+		port := "*"
+		if p.Port != nil {
+			port = p.Port.String()
+		}
+		d = append(d, fmt.Sprintf("%s/%s", protocol, port))
+	}
+	return strings.Join(d, ", ")
+}
+
+// servicePolicyPorts returns the target ports of a service as NetworkPolicy ports
+// A named targetPort is kept as the name so that the NetworkPolicy
+// stays valid if the number of the container port changes
+func servicePolicyPorts(svc v1.Service) []networkingv1.NetworkPolicyPort {
+	ports := []networkingv1.NetworkPolicyPort{}
+	for _, p := range svc.Spec.Ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = v1.ProtocolTCP
+		}
+		port := p.TargetPort
+		if port.Type == intstr.Int && port.IntVal == 0 {
+			// targetPort defaults to port
+			port = intstr.FromInt(int(p.Port))
+		}
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+	}
+	return ports
+}
+
+// toPodPolicyPorts returns the target ports of the toPod services the callers connected to
+// It returns nil (i.e., all ports) if a caller connected through a FQDN
+// which isn't one of the toPod services e.g., --target-fqdn or --extra-target-fqdn
+func (r *Runner) toPodPolicyPorts() []networkingv1.NetworkPolicyPort {
+	servicesByFQDN := map[string]v1.Service{}
+	for _, s := range r.toPodServices {
+		servicesByFQDN[fmt.Sprintf("%s.%s%s", s.Name, s.Namespace, r.logFilter.fqdnSuffix())] = s
+		if r.toPodHostnameFQDN != "" && r.toPod.Spec.Subdomain == s.Name {
+			servicesByFQDN[r.toPodHostnameFQDN] = s
+		}
+	}
+
+	ports := []networkingv1.NetworkPolicyPort{}
+	seen := map[string]bool{}
+	for hostname := range r.hostnamePodMapping {
+		s, ok := servicesByFQDN[hostname]
+		if !ok {
+			log.Debugf("%s is not a service of the pod, allowing all the ports", hostname)
+			return nil
+		}
+		// callers of a headless service connect to the pod IP directly
+		// so without service ports, any port of the pod can be used
+		if headlessService(s) && len(s.Spec.Ports) == 0 {
+			log.Debugf("headless service %s has no ports, allowing all the ports", hostname)
+			return nil
+		}
+		for _, p := range servicePolicyPorts(s) {
+			key := fmt.Sprintf("%s/%s", *p.Protocol, p.Port.String())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ports = append(ports, p)
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if *ports[i].Protocol != *ports[j].Protocol {
+			return *ports[i].Protocol < *ports[j].Protocol
+		}
+		return ports[i].Port.String() < ports[j].Port.String()
+	})
+	return ports
+}
+
+// peerSource is the rationale behind a peer in the suggested NetworkPolicy
+// i.e., the pods the peer was created from and the services they connected to
+type peerSource struct {
+	pods     []string
+	services []string
+	// queries is the number of queries from the pods to the services
+	queries int
+	samples []string
+}
+
+// add adds a pod (`<namespace>/<pod-name>`) and the service FQDN it connected to
+// along with the queries of its mapping
+func (p *peerSource) add(pod string, service string, m *Mapping) {
+	if !contains(p.pods, pod) {
+		p.pods = append(p.pods, pod)
+	}
+	if !contains(p.services, service) {
+		p.services = append(p.services, service)
+	}
+	p.queries += m.count
+	p.addSamples(m.samples)
+}
+
+// addSamples adds the log lines up to explainSampleLogs
+func (p *peerSource) addSamples(samples []string) {
+	for _, s := range samples {
+		if len(p.samples) >= explainSampleLogs {
+			return
+		}
+		p.samples = append(p.samples, s)
+	}
+}
+
+// merge adds the pods and services of o
+func (p *peerSource) merge(o *peerSource) {
+	for _, pod := range o.pods {
+		if !contains(p.pods, pod) {
+			p.pods = append(p.pods, pod)
+		}
+	}
+	for _, service := range o.services {
+		if !contains(p.services, service) {
+			p.services = append(p.services, service)
+		}
+	}
+	p.queries += o.queries
+	p.addSamples(o.samples)
+}
+
+func (p *peerSource) String() string {
+	sort.Strings(p.pods)
+	sort.Strings(p.services)
+	return fmt.Sprintf("pods: %s via svc: %s", strings.Join(p.pods, ", "), strings.Join(p.services, ", "))
+}
+
+// mergeSubsetPeers collapses every peer whose labels are a superset of another
+// peer's labels into that peer, keeping sources[i] as the rationale behind peers[i]
+// A peer with fewer labels selects every pod the peer with more labels selects,
+// so the merged policy allows the same callers with fewer peers. It also allows any
+// other pod matching the fewer labels, i.e., the scope of the policy can only grow
+func mergeSubsetPeers(peers []networkingv1.NetworkPolicyPeer, sources []*peerSource) ([]networkingv1.NetworkPolicyPeer, []*peerSource) {
+	// the peers with fewer labels come first so that
+	// a peer is always merged into the broadest one
+	order := make([]int, len(peers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(peers[order[i]].PodSelector.MatchLabels) < len(peers[order[j]].PodSelector.MatchLabels)
+	})
+
+	mergedPeers := []networkingv1.NetworkPolicyPeer{}
+	mergedSources := []*peerSource{}
+	for _, i := range order {
+		merged := false
+		for j, m := range mergedPeers {
+			if isSubset(m.PodSelector.MatchLabels, peers[i].PodSelector.MatchLabels) {
+				log.Debugf("merging peer %s into peer %s", metav1.FormatLabelSelector(peers[i].PodSelector), metav1.FormatLabelSelector(m.PodSelector))
+				mergedSources[j].merge(sources[i])
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			mergedPeers = append(mergedPeers, peers[i])
+			mergedSources = append(mergedSources, sources[i])
+		}
+	}
+	return mergedPeers, mergedSources
+}
+
+// isSubset returns true if all the labels in sub are in l
+func isSubset(sub, l map[string]string) bool {
+	for k, v := range sub {
+		if lv, ok := l[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// topNamespaces returns up to n namespaces with the most pods in the sources
+// as `<namespace> (<number of pods>)`
+func topNamespaces(sources []*peerSource, n int) []string {
+	counts := map[string]int{}
+	for _, s := range sources {
+		for _, pod := range s.pods {
+			counts[strings.SplitN(pod, "/", 2)[0]]++
+		}
+	}
+
+	namespaces := make([]string, 0, len(counts))
+	for ns := range counts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if counts[namespaces[i]] != counts[namespaces[j]] {
+			return counts[namespaces[i]] > counts[namespaces[j]]
+		}
+		return namespaces[i] < namespaces[j]
+	})
+	if len(namespaces) > n {
+		namespaces = namespaces[:n]
+	}
+
+	top := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		top[i] = fmt.Sprintf("%s (%d)", ns, counts[ns])
+	}
+	return top
+}
+
+// peersBySelector sorts the peers by their pod selector
+// keeping sources[i] as the rationale behind peers[i]
+type peersBySelector struct {
+	peers   []networkingv1.NetworkPolicyPeer
+	sources []*peerSource
+}
+
+func (p *peersBySelector) Len() int { return len(p.peers) }
+
+func (p *peersBySelector) Less(i, j int) bool {
+	return metav1.FormatLabelSelector(p.peers[i].PodSelector) < metav1.FormatLabelSelector(p.peers[j].PodSelector)
+}
+
+func (p *peersBySelector) Swap(i, j int) {
+	p.peers[i], p.peers[j] = p.peers[j], p.peers[i]
+	p.sources[i], p.sources[j] = p.sources[j], p.sources[i]
+}
+
+// annotatePeers adds a comment to every peer under `spec.ingress[0].from`
+// of the NetworkPolicy yaml node describing where the peer came from
+func annotatePeers(n *yaml.Node, sources []*peerSource) {
+	ingress := mappingValue(mappingValue(n, "spec"), "ingress")
+	if ingress == nil || len(ingress.Content) == 0 {
+		return
+	}
+
+	from := mappingValue(ingress.Content[0], "from")
+	if from == nil {
+		return
+	}
+
+	for i, peer := range from.Content {
+		if i < len(sources) {
+			peer.HeadComment = sources[i].String()
+		}
+	}
+}
+
+// mappingValue returns the value node for key in a yaml mapping node
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// withoutIgnoredLabels returns a copy of l without the `--ignore-pod-labels` keys
+// so that the pod objects (which may be shared via the pod index) aren't modified
+func (r *Runner) withoutIgnoredLabels(l map[string]string) map[string]string {
+	c := make(map[string]string, len(l))
+	for k, v := range l {
+		if !contains(r.ignoredPodLabels, k) {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// PolicyGranularitySingle suggests a single NetworkPolicy allowing all the callers (default)
+	PolicyGranularitySingle = "single"
+	// PolicyGranularityWorkload suggests a NetworkPolicy per calling workload
+	// e.g., for reviewing the policies along the team ownership boundaries
+	PolicyGranularityWorkload = "workload"
+)
+
+const (
+	// PolicyAPIVersionV1 is the apiVersion of the suggested NetworkPolicies (default)
+	PolicyAPIVersionV1 = "networking.k8s.io/v1"
+	// PolicyAPIVersionExtensionsV1beta1 is for clusters which predate PolicyAPIVersionV1
+	// It is deprecated since K8s 1.9 and not served since K8s 1.16
+	PolicyAPIVersionExtensionsV1beta1 = "extensions/v1beta1"
+)
+
+// PolicyAPIVersions are the supported apiVersions of the suggested NetworkPolicies
+// The suggested NetworkPolicies have the same shape in all of them
+var PolicyAPIVersions = []string{PolicyAPIVersionV1, PolicyAPIVersionExtensionsV1beta1}
+
+// suggestedNetPols returns the suggested NetworkPolicies with the sources of their peers
+// as per the policyGranularity
+func (r *Runner) suggestedNetPols() ([]*networkingv1.NetworkPolicy, [][]*peerSource, error) {
+	if r.policyGranularity != PolicyGranularityWorkload {
+		n, sources, err := r.buildNetPol()
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*networkingv1.NetworkPolicy{n}, [][]*peerSource{sources}, nil
+	}
+
+	owners := []Workload{}
+	for _, mappings := range r.hostnamePodMapping {
+		for _, m := range mappings {
+			w, err := r.podOwner(m.podname, m.namespace)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !containsWorkload(owners, *w) {
+				owners = append(owners, *w)
+			}
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i].String() < owners[j].String()
+	})
+
+	nps := []*networkingv1.NetworkPolicy{}
+	allSources := [][]*peerSource{}
+	for i := range owners {
+		n, sources, err := r.buildNetPolFrom(&owners[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		// an ingress rule without peers allows everything
+		if len(n.Spec.Ingress[0].From) == 0 {
+			continue
+		}
+		nps = append(nps, n)
+		allSources = append(allSources, sources)
+	}
+	return nps, allSources, nil
+}
+
+func containsWorkload(workloads []Workload, w Workload) bool {
+	for _, o := range workloads {
+		if o == w {
+			return true
+		}
+	}
+	return false
+}
+
+// headlessService returns true if the service has no virtual IP (`clusterIP: None`)
+// i.e., its FQDN resolves to the IPs of the pods it selects
+func headlessService(s v1.Service) bool {
+	return s.Spec.ClusterIP == v1.ClusterIPNone
+}
+
+// printHeadlessServices notes the headless services of the toPod
+// because their callers connect to the pod IPs instead of a virtual IP
+func (r *Runner) printHeadlessServices() {
+	for _, s := range r.toPodServices {
+		if !headlessService(s) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "note: service %s (ns %s) is headless, its callers connect to the pod IPs directly\n", r.anonymizer.name("svc", s.Name), r.anonymizer.Namespace(s.Namespace))
+	}
+	if r.toPodHostnameFQDN != "" {
+		fmt.Fprintf(os.Stderr, "note: queries for the pod's own FQDN %s are listed under it\n", r.anonymizer.fqdn(r.toPodHostnameFQDN))
+	}
+}
@@ -0,0 +1,193 @@
+package corednsrunner
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// caller is a pod calling the toPod through the FQDN
+type caller struct {
+	pod    *v1.Pod
+	ip     string
+	toFQDN string
+}
+
+// service is a Service of the toPod in `sock-shop`
+func service(name string, ports ...v1.ServicePort) v1.Service {
+	return v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "sock-shop"},
+		Spec:       v1.ServiceSpec{Selector: map[string]string{"app": "user-db"}, Ports: ports},
+	}
+}
+
+// policyRunner returns a Runner for the toPod `user-db-0` in `sock-shop`
+// with the callers processed from their CoreDNS logs
+func policyRunner(t *testing.T, services []v1.Service, callers ...caller) *Runner {
+	fqdns := []string{}
+	for _, s := range services {
+		fqdns = append(fqdns, fmt.Sprintf("%s.%s%s", s.Name, s.Namespace, DefaultLogFilter.fqdnSuffix()))
+	}
+
+	r := connectionRunner(fqdns, nil)
+	r.toPod.Labels = map[string]string{"app": "user-db", "pod-template-hash": "b8dfb847c"}
+	r.toPodServices = services
+	r.clientset = fake.NewSimpleClientset()
+	r.ignoredPodLabels = DefaultIgnoredPodLabels
+	r.podOwners = map[string]*Workload{}
+	addCallers(t, r, callers...)
+	return r
+}
+
+// addCallers adds the caller pods to the cluster and processes their CoreDNS logs
+func addCallers(t *testing.T, r *Runner, callers ...caller) {
+	for _, c := range callers {
+		if err := r.clientset.(*fake.Clientset).Tracker().Add(c.pod); err != nil {
+			t.Fatalf("couldn't add the pod %s: %v", c.pod.Name, err)
+		}
+		if r.allEndpoints[c.pod.Namespace] == nil {
+			r.allNamespaces.Items = append(r.allNamespaces.Items, v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: c.pod.Namespace}})
+			r.allEndpoints[c.pod.Namespace] = &v1.EndpointsList{}
+		}
+		eps := r.allEndpoints[c.pod.Namespace]
+		eps.Items = append(eps.Items, endpoints(c.pod.Namespace, c.pod.Name, podAddress(c.ip, c.pod.Name, c.pod.Namespace)))
+	}
+	r.indexPodsByIP()
+
+	for _, c := range callers {
+		l, err, ok := parseLogMsg(queryLog(c.ip, c.toFQDN), DefaultLogFilter)
+		if err != nil || !ok {
+			t.Fatalf("parseLogMsg() = %v, %v", err, ok)
+		}
+		if err := r.processConnectionLog(l); err != nil {
+			t.Fatalf("processConnectionLog() error = %v", err)
+		}
+	}
+}
+
+// labeledPod is a pod with the labels
+func labeledPod(namespace, name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+}
+
+func TestNetPolYAMLIsStable(t *testing.T) {
+	services := []v1.Service{
+		service("user-db", v1.ServicePort{Port: 27017}),
+		service("user-db-headless", v1.ServicePort{Port: 27017}),
+	}
+	callers := []caller{}
+	for i := 0; i < 12; i++ {
+		ns := []string{"sock-shop", "orders", "payments"}[i%3]
+		labels := map[string]string{
+			"app":               fmt.Sprintf("app-%d", i%4),
+			"tier":              []string{"front", "back"}[i%2],
+			"version":           "v1",
+			"pod-template-hash": fmt.Sprintf("hash-%d", i),
+		}
+		callers = append(callers, caller{
+			pod:    labeledPod(ns, fmt.Sprintf("caller-%d", i), labels),
+			ip:     fmt.Sprintf("10.42.0.%d", i+10),
+			toFQDN: fmt.Sprintf("%s.sock-shop.svc.cluster.local.", services[i%2].Name),
+		})
+	}
+
+	tests := []struct {
+		name        string
+		explain     bool
+		granularity string
+	}{
+		{name: "single policy"},
+		{name: "single policy explained", explain: true},
+		{name: "a policy per workload", granularity: PolicyGranularityWorkload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first []byte
+			// the maps the policy is built from are iterated in a random order every run
+			for run := 0; run < 10; run++ {
+				r := policyRunner(t, services, callers...)
+				r.explain = tt.explain
+				r.policyGranularity = tt.granularity
+
+				got, err := r.netPolYAML()
+				if err != nil {
+					t.Fatalf("netPolYAML() error = %v", err)
+				}
+				if run == 0 {
+					first = got
+					continue
+				}
+				if !bytes.Equal(got, first) {
+					t.Fatalf("run %d rendered a different policy:\n%s\nthe first run rendered:\n%s", run, got, first)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildNetPolPorts(t *testing.T) {
+	const fqdn = "user-db.sock-shop.svc.cluster.local."
+	front := labeledPod("sock-shop", "front-end-0", map[string]string{"app": "front-end"})
+	tests := []struct {
+		name     string
+		services []v1.Service
+		// targetFQDN replaces the FQDNs of the services e.g., --target-fqdn
+		targetFQDN string
+		wantPorts  string
+	}{
+		{
+			name:      "named target port",
+			services:  []v1.Service{service("user-db", v1.ServicePort{Name: "mongo", Port: 27017, TargetPort: intstr.FromString("mongo")})},
+			wantPorts: "TCP/mongo",
+		},
+		{
+			name:      "numbered target port",
+			services:  []v1.Service{service("user-db", v1.ServicePort{Port: 80, TargetPort: intstr.FromInt(27017)})},
+			wantPorts: "TCP/27017",
+		},
+		{
+			name:      "target port defaulting to the port",
+			services:  []v1.Service{service("user-db", v1.ServicePort{Port: 27017})},
+			wantPorts: "TCP/27017",
+		},
+		{
+			name: "named and numbered target ports",
+			services: []v1.Service{service("user-db",
+				v1.ServicePort{Name: "mongo", Port: 27017, TargetPort: intstr.FromString("mongo")},
+				v1.ServicePort{Name: "metrics", Port: 9216, TargetPort: intstr.FromInt(9216), Protocol: v1.ProtocolTCP},
+				v1.ServicePort{Name: "dns", Port: 53, TargetPort: intstr.FromString("dns"), Protocol: v1.ProtocolUDP},
+			)},
+			wantPorts: "TCP/9216, TCP/mongo, UDP/dns",
+		},
+		{
+			name:       "FQDN which isn't a service of the pod",
+			services:   []v1.Service{service("user-db-alias", v1.ServicePort{Name: "mongo", Port: 27017, TargetPort: intstr.FromString("mongo")})},
+			targetFQDN: fqdn,
+			wantPorts:  "all ports",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := policyRunner(t, tt.services)
+			if tt.targetFQDN != "" {
+				r.toPodServiceFQDNs = []string{tt.targetFQDN}
+			}
+			addCallers(t, r, caller{pod: front, ip: "10.42.0.8", toFQDN: fqdn})
+
+			n, _, err := r.buildNetPol()
+			if err != nil {
+				t.Fatalf("buildNetPol() error = %v", err)
+			}
+			if got := describePorts(n.Spec.Ingress[0].Ports); got != tt.wantPorts {
+				t.Errorf("ports = %s, want %s", got, tt.wantPorts)
+			}
+		})
+	}
+}
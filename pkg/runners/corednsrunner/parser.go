@@ -0,0 +1,22 @@
+package corednsrunner
+
+// LogParser parses a DNS log line into a ConnectionLog
+// Implement it to support DNS servers or proxies with a custom log format
+type LogParser interface {
+	// Parse returns false if the line is not a relevant connection log
+	Parse(line string) (*ConnectionLog, bool, error)
+}
+
+// CoreDNSLogParser parses the default log format of the CoreDNS `log` plugin
+// More info: https://coredns.io/plugins/log/#log-format
+type CoreDNSLogParser struct {
+	Filter LogFilter
+}
+
+func (p *CoreDNSLogParser) Parse(line string) (*ConnectionLog, bool, error) {
+	c, err, success := parseLogMsg(line, p.Filter)
+	if c != nil {
+		c.Line = line
+	}
+	return c, success, err
+}
@@ -0,0 +1,76 @@
+package corednsrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PolicyList collects the suggested NetworkPolicies of multiple runners
+// so that they can be printed as a single `kind: List` object
+type PolicyList struct {
+	mu    sync.Mutex
+	items []*networkingv1.NetworkPolicy
+}
+
+// Add adds a NetworkPolicy to the list
+func (l *PolicyList) Add(n *networkingv1.NetworkPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = append(l.items, n)
+}
+
+// Write writes the list as JSON if output is OutputJSON or OutputWideJSON, otherwise as YAML
+func (l *PolicyList) Write(w io.Writer, output string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := v1.List{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "List",
+			APIVersion: "v1",
+		},
+		Items: []runtime.RawExtension{},
+	}
+	for _, n := range l.items {
+		raw, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		list.Items = append(list.Items, runtime.RawExtension{Raw: raw})
+	}
+
+	if output == OutputJSON || output == OutputWideJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+
+	y, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	v := map[string]interface{}{}
+	if err := json.Unmarshal(y, &v); err != nil {
+		return err
+	}
+
+	b, err := encodeYAML(&v)
+	if err != nil {
+		return err
+	}
+
+	if output == OutputMarkdown {
+		_, err = fmt.Fprintf(w, "\n### Suggested NetworkPolicies\n\n```yaml\n%s```\n", string(b))
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,81 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GroupByProtocol splits the connections by the transport (UDP or TCP) of their queries
+// e.g., TCP often means large responses which don't fit in UDP
+const GroupByProtocol = "protocol"
+
+// unknownTransport is the transport of the queries a LogParser didn't set the transport of
+const unknownTransport = "unknown"
+
+// addTransport counts a query from the pod by its transport
+func (m *Mapping) addTransport(transport string) {
+	if transport == "" {
+		transport = unknownTransport
+	}
+	if m.transports == nil {
+		m.transports = map[string]int{}
+	}
+	m.transports[transport]++
+}
+
+// sortedTransports sorts udp and tcp first, then the other transports alphabetically
+func sortedTransports(transports map[string]int) []string {
+	rank := func(t string) int {
+		switch t {
+		case "udp":
+			return 0
+		case "tcp":
+			return 1
+		}
+		return 2
+	}
+
+	sorted := []string{}
+	for t := range transports {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if rank(sorted[i]) != rank(sorted[j]) {
+			return rank(sorted[i]) < rank(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// printConnectionsByProtocol prints the connections in a section per transport
+// with the number of queries of every connection over the transport
+func (r *Runner) printConnectionsByProtocol() {
+	byTransport := map[string][]Connection{}
+	queries := map[string]int{}
+	for _, c := range r.connections() {
+		for t, n := range c.Transports {
+			byTransport[t] = append(byTransport[t], c)
+			queries[t] += n
+		}
+	}
+
+	for _, t := range sortedTransports(queries) {
+		fmt.Printf("%s (%d queries):\n", strings.ToUpper(t), queries[t])
+		for _, c := range byTransport[t] {
+			pod := r.colorize(colorPod, r.anonymizer.Pod(c.FromPod))
+			ns := r.colorize(colorNamespace, r.anonymizer.Namespace(c.FromNamespace))
+			svc := r.colorize(colorService, r.anonymizer.fqdn(c.ToFQDN))
+			fmt.Printf("pod: %s, ns: %s via svc: %s%s (%d queries)\n", pod, ns, svc, relationSuffix(c.Relation), c.Transports[t])
+		}
+	}
+}
+
+// groupedTransports returns the queries of the mapping per transport with GroupByProtocol
+func (r *Runner) groupedTransports(m *Mapping) map[string]int {
+	if r.groupBy != GroupByProtocol {
+		return nil
+	}
+	return m.transports
+}
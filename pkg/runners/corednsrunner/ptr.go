@@ -0,0 +1,63 @@
+package corednsrunner
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	ipv4PTRSuffix = ".in-addr.arpa."
+	ipv6PTRSuffix = ".ip6.arpa."
+)
+
+// matchesPTR returns true if IncludePTR is set and the log is of a reverse (PTR) query
+// e.g., [INFO] 10.42.2.91:41234 - 3121 "PTR IN 90.2.42.10.in-addr.arpa. udp 42 false 512" NOERROR qr,aa,rd 110 0.000213s
+func (f LogFilter) matchesPTR(rawText string) bool {
+	if !f.IncludePTR || !strings.Contains(rawText, "\"PTR IN ") {
+		return false
+	}
+
+	qname, _, ok := queryRcode(rawText, f.LogLevelMarker)
+	return ok && ptrIP(qname) != ""
+}
+
+// ptrIP returns the IP embedded in a reverse lookup name
+// e.g., `10.42.2.90` for `90.2.42.10.in-addr.arpa.`
+// It returns an empty string if the name is not a reverse lookup of a full IP
+func ptrIP(qname string) string {
+	if strings.HasSuffix(qname, ipv4PTRSuffix) {
+		octets := strings.Split(strings.TrimSuffix(qname, ipv4PTRSuffix), ".")
+		if len(octets) != 4 {
+			return ""
+		}
+		for i, j := 0, len(octets)-1; i < j; i, j = i+1, j-1 {
+			octets[i], octets[j] = octets[j], octets[i]
+		}
+		ip := net.ParseIP(strings.Join(octets, "."))
+		if ip == nil {
+			return ""
+		}
+		return normalizeIP(ip.String())
+	}
+
+	if strings.HasSuffix(qname, ipv6PTRSuffix) {
+		nibbles := strings.Split(strings.TrimSuffix(qname, ipv6PTRSuffix), ".")
+		if len(nibbles) != 32 {
+			return ""
+		}
+		var b strings.Builder
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			b.WriteString(nibbles[i])
+			if i%4 == 0 && i > 0 {
+				b.WriteString(":")
+			}
+		}
+		ip := net.ParseIP(b.String())
+		if ip == nil {
+			return ""
+		}
+		return normalizeIP(ip.String())
+	}
+
+	return ""
+}
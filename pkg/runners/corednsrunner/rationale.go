@@ -0,0 +1,67 @@
+package corednsrunner
+
+import (
+	"sort"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// explainSampleLogs is the maximum number of log lines kept per caller pod and per peer
+const explainSampleLogs = 3
+
+// PeerRationale is the rationale behind a peer of a suggested NetworkPolicy
+// i.e., what Explain annotates the peer with, for policy review tooling
+type PeerRationale struct {
+	// Policy is the name of the NetworkPolicy the peer is in
+	Policy string `json:"policy"`
+	// PodSelector is the pod selector of the peer e.g., `app=orders`
+	PodSelector string `json:"podSelector"`
+	// Pods are the calling pods (`<namespace>/<pod-name>`) the peer was created from
+	Pods []string `json:"pods"`
+	// Services are the service FQDNs the pods queried
+	Services []string `json:"services"`
+	// Queries is the number of queries from the pods to the services
+	Queries int `json:"queries"`
+	// SampleLogs are the first CoreDNS log lines of the queries (not set with an Anonymizer)
+	SampleLogs []string `json:"sampleLogs,omitempty"`
+}
+
+// addSample keeps the log line if there are fewer than explainSampleLogs
+func (m *Mapping) addSample(line string) {
+	if line == "" || len(m.samples) >= explainSampleLogs {
+		return
+	}
+	m.samples = append(m.samples, line)
+}
+
+// rationale returns the rationale behind every peer of the NetworkPolicies
+// where sources[i][j] is the rationale behind the peer j of nps[i]
+func (r *Runner) rationale(nps []*networkingv1.NetworkPolicy, sources [][]*peerSource) []PeerRationale {
+	rationale := []PeerRationale{}
+	for i, n := range nps {
+		// sources are of the pod peers in the first ingress rule only
+		// (there are none if it's the rule of the ipBlock peers)
+		for j, src := range sources[i] {
+			peer := n.Spec.Ingress[0].From[j]
+			pods := append([]string{}, src.pods...)
+			services := append([]string{}, src.services...)
+			sort.Strings(pods)
+			sort.Strings(services)
+
+			p := PeerRationale{
+				Policy:      n.Name,
+				PodSelector: metav1.FormatLabelSelector(peer.PodSelector),
+				Pods:        pods,
+				Services:    services,
+				Queries:     src.queries,
+			}
+			// the log lines have the real IPs and names
+			if r.anonymizer == nil {
+				p.SampleLogs = src.samples
+			}
+			rationale = append(rationale, p)
+		}
+	}
+	return rationale
+}
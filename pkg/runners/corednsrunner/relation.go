@@ -0,0 +1,73 @@
+package corednsrunner
+
+const (
+	// RelationCaller is a pod which isn't behind the services of the target
+	RelationCaller = "caller"
+	// RelationSibling is another pod behind the services of the target (or of the target workload)
+	// e.g., a replica of the same StatefulSet looking up its peers
+	RelationSibling = "sibling"
+	// RelationSelf is the target pod querying its own services
+	RelationSelf = "self"
+)
+
+// relation classifies the relationship of a calling pod to the toPod
+// using the endpoints of the toPod services
+func (r *Runner) relation(podname, namespace string) string {
+	if podname == r.toPod.Name && namespace == r.toPod.Namespace {
+		return RelationSelf
+	}
+
+	if r.siblingPods == nil {
+		r.indexSiblingPods()
+	}
+	if r.siblingPods[namespace+"/"+podname] {
+		return RelationSibling
+	}
+	return RelationCaller
+}
+
+// indexSiblingPods indexes the pods in the endpoints of the toPod services and the workloadPods
+func (r *Runner) indexSiblingPods() {
+	r.siblingPods = map[string]bool{}
+	// the other replicas of the target workload even if they aren't behind its services
+	for _, p := range r.workloadPods {
+		r.siblingPods[p.Namespace+"/"+p.Name] = true
+	}
+
+	eps, ok := r.allEndpoints[r.toPod.Namespace]
+	if !ok {
+		return
+	}
+	services := map[string]bool{}
+	for _, s := range r.toPodServices {
+		services[s.Name] = true
+	}
+
+	for _, e := range eps.Items {
+		if !services[e.Name] {
+			continue
+		}
+		for _, s := range e.Subsets {
+			addresses := append(s.Addresses, s.NotReadyAddresses...)
+			for _, a := range addresses {
+				if a.TargetRef == nil || a.TargetRef.Kind != "Pod" {
+					continue
+				}
+				namespace := a.TargetRef.Namespace
+				if namespace == "" {
+					namespace = e.Namespace
+				}
+				r.siblingPods[namespace+"/"+a.TargetRef.Name] = true
+			}
+		}
+	}
+}
+
+// relationSuffix annotates the text output of a connection which isn't from a caller
+// e.g., ` (sibling)`
+func relationSuffix(relation string) string {
+	if relation == RelationCaller {
+		return ""
+	}
+	return " (" + relation + ")"
+}
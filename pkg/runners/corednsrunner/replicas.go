@@ -0,0 +1,103 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// podIPFQDN is the FQDN of the A (or AAAA) record of a pod IP
+// e.g., `10-42-2-90.sock-shop.pod.cluster.local.`
+// https://kubernetes.io/docs/concepts/services-networking/dns-pod-service/#a-aaaa-records-1
+func podIPFQDN(ip, namespace, clusterDomain string) string {
+	return fmt.Sprintf("%s.%s.pod.%s.", strings.NewReplacer(".", "-", ":", "-").Replace(ip), namespace, clusterDomain)
+}
+
+// workloadPodsOf lists the pods owned by the workload directly or through
+// another workload e.g., the pods of all the ReplicaSets of a Deployment
+func (r *Runner) workloadPodsOf(w *Workload) ([]v1.Pod, error) {
+	pods, err := r.podsInNamespace(w.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []v1.Pod{}
+	for i := range pods {
+		if w.Kind == "Pod" {
+			if pods[i].Name == w.Name {
+				owned = append(owned, pods[i])
+			}
+			continue
+		}
+
+		owners, err := r.ownerChain(&pods[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range owners {
+			if o == *w {
+				owned = append(owned, pods[i])
+				break
+			}
+		}
+	}
+	return owned, nil
+}
+
+// indexWorkloadPods indexes the pods of the target workload, their IPs
+// and the FQDNs of their pod A records so that the queries for any replica
+// (not only for the toPod) count as incoming connections to the workload
+// Host network pods are skipped because they share the node IP
+func (r *Runner) indexWorkloadPods(w *Workload) error {
+	pods, err := r.workloadPodsOf(w)
+	if err != nil {
+		return err
+	}
+
+	r.workloadPods = []v1.Pod{}
+	r.workloadPodIPs = map[string]bool{}
+	r.workloadPodFQDNs = []string{}
+	for _, p := range pods {
+		if p.Spec.HostNetwork {
+			continue
+		}
+		r.workloadPods = append(r.workloadPods, p)
+
+		ips := []string{p.Status.PodIP}
+		for _, ip := range p.Status.PodIPs {
+			ips = append(ips, ip.IP)
+		}
+		for _, ip := range ips {
+			ip = normalizeIP(ip)
+			if ip == "" || r.workloadPodIPs[ip] {
+				continue
+			}
+			r.workloadPodIPs[ip] = true
+			r.workloadPodFQDNs = append(r.workloadPodFQDNs, podIPFQDN(ip, p.Namespace, r.logFilter.ClusterDomain))
+		}
+	}
+
+	log.Infof("matching the %d IP(s) of %d pod(s) of %s", len(r.workloadPodIPs), len(r.workloadPods), r.anonymizer.workload(*w))
+	return nil
+}
+
+// addWorkloadPodHostnames adds the FQDNs of the pods of the target workload
+// through the headless toPod services to workloadPodFQDNs
+// e.g., user-db-1.user-db.sock-shop.svc.cluster.local. for a StatefulSet replica
+func (r *Runner) addWorkloadPodHostnames() {
+	for _, s := range r.toPodServices {
+		if !headlessService(s) {
+			continue
+		}
+		for _, p := range r.workloadPods {
+			if p.Spec.Hostname == "" || p.Spec.Subdomain != s.Name {
+				continue
+			}
+			fqdn := fmt.Sprintf("%s.%s.%s%s", p.Spec.Hostname, s.Name, s.Namespace, r.logFilter.fqdnSuffix())
+			if fqdn != r.toPodHostnameFQDN && !contains(r.workloadPodFQDNs, fqdn) {
+				r.workloadPodFQDNs = append(r.workloadPodFQDNs, fqdn)
+			}
+		}
+	}
+}
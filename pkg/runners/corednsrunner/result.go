@@ -0,0 +1,240 @@
+package corednsrunner
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// ResultAPIVersion is the version of the Result schema
+// It changes whenever the schema changes in a backward incompatible way
+const ResultAPIVersion = "kico/v1"
+
+// Result is what gets printed for `--output json`, `--output wide-json` and `--output yaml`
+type Result struct {
+	APIVersion    string         `json:"apiVersion"`
+	Target        Target         `json:"target"`
+	Parameters    Parameters     `json:"parameters"`
+	Connections   []Connection   `json:"connections"`
+	WorkloadEdges []WorkloadEdge `json:"workloadEdges"`
+	// Callers are the calling pods with their metadata (set with OutputWideJSON)
+	Callers []Caller `json:"callers,omitempty"`
+	// Unresolved are the connections from IPs which couldn't be matched to a pod
+	Unresolved    []Unresolved                `json:"unresolved"`
+	NetworkPolicy *networkingv1.NetworkPolicy `json:"networkPolicy,omitempty"`
+	// NetworkPolicies are the NetworkPolicies per calling workload
+	// (set with PolicyGranularityWorkload instead of NetworkPolicy)
+	NetworkPolicies []*networkingv1.NetworkPolicy `json:"networkPolicies,omitempty"`
+	// Rationale is the rationale behind every peer of the suggested NetworkPolicies (set with Explain)
+	Rationale []PeerRationale `json:"rationale,omitempty"`
+	// DNSEgressNetworkPolicies are set with SmartDNSEgress
+	DNSEgressNetworkPolicies []*networkingv1.NetworkPolicy `json:"dnsEgressNetworkPolicies,omitempty"`
+	// DNSHealth is the count of every response code
+	// seen for the queries to the pod's services
+	DNSHealth map[string]int `json:"dnsHealth"`
+	// Warnings are the warnings collected during the analysis (see Runner.Warnings)
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// Target identifies the pod whose incoming connections are in the Result
+type Target struct {
+	Pod       string `json:"pod"`
+	Namespace string `json:"namespace"`
+	// IP is set if the pod was found using its IP
+	IP string `json:"ip,omitempty"`
+	// Context is the kubeconfig context the pod is from
+	Context      string   `json:"context,omitempty"`
+	ServiceFQDNs []string `json:"serviceFQDNs"`
+}
+
+// Parameters are the parameters used to produce the Result
+// so that the Result can be reproduced
+type Parameters struct {
+	WaitForLogs          string    `json:"waitForLogs"`
+	CoreDNSNamespace     string    `json:"corednsNamespace"`
+	CoreDNSSelector      string    `json:"corednsSelector"`
+	CoreDNSFieldSelector string    `json:"corednsFieldSelector,omitempty"`
+	LogFilter            LogFilter `json:"logFilter"`
+	IgnoredPodLabels     []string  `json:"ignoredPodLabels"`
+	ExcludedNamespaces   []string  `json:"excludedNamespaces,omitempty"`
+}
+
+// parameters returns the Parameters the runner was configured with
+func (r *Runner) parameters() Parameters {
+	return Parameters{
+		WaitForLogs:          r.waitForLogsDuration.String(),
+		CoreDNSNamespace:     r.corednsNamespace,
+		CoreDNSSelector:      r.corednsSelector,
+		CoreDNSFieldSelector: r.corednsFieldSelector,
+		LogFilter:            r.logFilter,
+		IgnoredPodLabels:     r.ignoredPodLabels,
+		ExcludedNamespaces:   r.excludedNamespaces,
+	}
+}
+
+// result builds the Result
+func (r *Runner) result(edges []WorkloadEdge) (*Result, error) {
+	res := &Result{
+		APIVersion: ResultAPIVersion,
+		Target: Target{
+			Pod:          r.anonymizer.Pod(r.toPod.Name),
+			Namespace:    r.anonymizer.Namespace(r.toPod.Namespace),
+			IP:           r.toPodIP,
+			Context:      r.kubeContext,
+			ServiceFQDNs: []string{},
+		},
+		Parameters:    r.parameters(),
+		Connections:   []Connection{},
+		WorkloadEdges: []WorkloadEdge{},
+		Unresolved:    []Unresolved{},
+		DNSHealth:     r.rcodes,
+	}
+	for _, f := range r.toPodServiceFQDNs {
+		res.Target.ServiceFQDNs = append(res.Target.ServiceFQDNs, r.anonymizer.fqdn(f))
+	}
+	for _, c := range r.connections() {
+		res.Connections = append(res.Connections, r.anonymizer.connection(c))
+	}
+	for _, u := range r.unresolved {
+		res.Unresolved = append(res.Unresolved, r.anonymizer.unresolved(u))
+	}
+	sort.Slice(res.Unresolved, func(i, j int) bool {
+		if res.Unresolved[i].ToFQDN != res.Unresolved[j].ToFQDN {
+			return res.Unresolved[i].ToFQDN < res.Unresolved[j].ToFQDN
+		}
+		return res.Unresolved[i].IP < res.Unresolved[j].IP
+	})
+	if r.output == OutputWideJSON {
+		callers, err := r.callers()
+		if err != nil {
+			return nil, err
+		}
+		res.Callers = []Caller{}
+		for _, c := range callers {
+			res.Callers = append(res.Callers, r.anonymizer.caller(c))
+		}
+	}
+	for _, e := range edges {
+		res.WorkloadEdges = append(res.WorkloadEdges, WorkloadEdge{From: r.anonymizer.workload(e.From), To: r.anonymizer.workload(e.To)})
+	}
+
+	// with a PolicyList, the NetworkPolicy is printed as a part of the list
+	if r.suggestNetworkPolicy && r.policyList == nil {
+		nps, sources, err := r.suggestedNetPols()
+		if err != nil {
+			return nil, err
+		}
+		if r.policyGranularity == PolicyGranularityWorkload {
+			res.NetworkPolicies = nps
+		} else {
+			res.NetworkPolicy = nps[0]
+		}
+		if r.explain {
+			res.Rationale = r.rationale(nps, sources)
+		}
+
+		if r.smartDNSEgress {
+			res.DNSEgressNetworkPolicies, err = r.dnsEgressNetPols()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	res.Warnings = r.Warnings()
+	return res, nil
+}
+
+// Analyze processes the connection logs without printing the connections
+// and returns the Result (the same as `--output json`) e.g., for using kico as a library
+// Unlike Run, the result is not printed (warnings are still logged)
+func (r *Runner) Analyze() (*Result, error) {
+	if err := r.analyzeConnectionLogs(); err != nil {
+		return nil, err
+	}
+
+	edges, err := r.workloadEdges()
+	if err != nil {
+		return nil, err
+	}
+	return r.result(edges)
+}
+
+// SuggestedNetworkPolicy processes the connection logs without printing the connections
+// and returns the suggested NetworkPolicy e.g., for applying it with the caller's own client
+// It is the single NetworkPolicy allowing all the callers regardless of the PolicyGranularity
+func (r *Runner) SuggestedNetworkPolicy() (*networkingv1.NetworkPolicy, error) {
+	if err := r.analyzeConnectionLogs(); err != nil {
+		return nil, err
+	}
+
+	n, _, err := r.buildNetPol()
+	return n, err
+}
+
+// ConnectionLogs returns the relevant connection logs parsed by Initialize
+// e.g., for the caller's own correlation or analytics
+// They are all the parsed queries for the cluster domain (and the extra FQDNs),
+// not only the ones for the toPod. The logs followed in watch mode aren't kept
+func (r *Runner) ConnectionLogs() []*ConnectionLog {
+	return append([]*ConnectionLog{}, r.connectionLogs...)
+}
+
+// analyzeConnectionLogs processes the connection logs silently
+// The logs are processed only once, so that the query counts aren't doubled
+// when both Analyze and SuggestedNetworkPolicy are called
+func (r *Runner) analyzeConnectionLogs() error {
+	if r.analyzed {
+		return nil
+	}
+
+	r.silent = true
+	defer func() { r.silent = false }()
+
+	if err := r.processConnectionLogs(); err != nil {
+		return err
+	}
+	r.analyzed = true
+	return nil
+}
+
+// writeJSON writes the result as JSON
+func (r *Runner) writeJSON(w io.Writer, edges []WorkloadEdge) error {
+	res, err := r.result(edges)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// writeYAML writes the result as YAML
+// It goes through JSON so that the field names are the same as in writeJSON
+func (r *Runner) writeYAML(w io.Writer, edges []WorkloadEdge) error {
+	res, err := r.result(edges)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	v := map[string]interface{}{}
+	if err := json.Unmarshal(j, &v); err != nil {
+		return err
+	}
+
+	b, err := encodeYAML(&v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
@@ -1,13 +1,16 @@
 package corednsrunner
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,39 +20,163 @@ import (
 	"gopkg.in/yaml.v3"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 var (
-	log              *logrus.Logger
-	ignoredPodLabels = []string{
+	log *logrus.Logger
+	// DefaultIgnoredPodLabels are labels generated by controllers which are
+	// unique per pod (or per revision) and hence not useful in a NetworkPolicy
+	DefaultIgnoredPodLabels = []string{
+		// Deployment/ReplicaSet
 		"pod-template-hash",
+		// StatefulSet/DaemonSet
+		"controller-revision-hash",
+		// StatefulSet
+		"statefulset.kubernetes.io/pod-name",
+		"apps.kubernetes.io/pod-index",
+		// DaemonSet
+		"pod-template-generation",
+		// Job (unique per run of a CronJob)
+		"job-name",
+		"controller-uid",
+		"batch.kubernetes.io/job-name",
+		"batch.kubernetes.io/controller-uid",
+	}
+	// SystemNamespaces are the namespaces created by K8s itself
+	SystemNamespaces = []string{
+		"kube-system",
+		"kube-public",
+		"kube-node-lease",
 	}
 )
 
 const (
-	corednsNamespace        = "kube-system"
-	corednsPodLabels        = "k8s-app=kube-dns"
-	logNotFound      string = "%s: waited %v for the relevant log to appear but it didn't"
-	fqdnSuffix              = ".svc.cluster.local."
+	DefaultCoreDNSNamespace        = "kube-system"
+	DefaultCoreDNSSelector         = "k8s-app=kube-dns"
+	DefaultDNSServiceName          = "kube-dns"
+	logNotFound             string = "%s: waited %v for the relevant log to appear but it didn't"
+
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+	// OutputWide is OutputText with the query ID, the transport and the last seen age of the connections
+	OutputWide = "wide"
+	// OutputTable is OutputText with the connections in aligned columns
+	OutputTable = "table"
+	// OutputWideJSON is OutputJSON with the metadata of every caller pod
+	OutputWideJSON = "wide-json"
+
+	// WatchModeStream follows the CoreDNS logs
+	WatchModeStream = "stream"
+	// WatchModePoll fetches the new CoreDNS logs every PollInterval
+	// for clusters where following the logs is unreliable e.g., behind proxies buffering the logs
+	WatchModePoll       = "poll"
+	DefaultPollInterval = 10 * time.Second
+
+	// podsByIPRefreshInterval is the minimum interval between re-fetching
+	// the endpoints for resolving an unknown caller IP
+	podsByIPRefreshInterval = 30 * time.Second
 )
 
 type ConnectionLog struct {
-	FromIP     string
+	// FromIP is normalized for matching against endpoints
+	// i.e., without the IPv6 zone and with IPv4-mapped IPv6 converted to IPv4
+	FromIP string
+	// RawFromIP is FromIP as it appears in the log
+	RawFromIP  string
 	ToHostname string
 	Status     string
 	FromPort   string
+	// QueryID is the DNS message ID e.g., for correlating with packet captures
+	QueryID string
+	// Transport is `udp` or `tcp`
+	Transport string
+	// PTRIP is the IP looked up if the log is of a reverse (PTR) query
+	PTRIP string
+	// ResponseSize is the size of the response in bytes (0 if it isn't logged)
+	ResponseSize int
+	// Line is the log line (set by CoreDNSLogParser)
+	Line string
+	// Time is when the query was logged (zero if the logs were read without timestamps)
+	Time time.Time
+}
+
+// LogFilter decides which CoreDNS logs are relevant
+type LogFilter struct {
+	// LogLevelMarker is the prefix of the relevant logs
+	LogLevelMarker string `json:"logLevelMarker"`
+	// RequireNoError keeps only the logs of successful queries
+	RequireNoError bool `json:"requireNoError"`
+	// ContinuationPrefix is the prefix of the lines which continue the previous line
+	// e.g., for a multi-line log format or wrapped long queries (empty means none)
+	ContinuationPrefix string `json:"continuationPrefix,omitempty"`
+	// ExtraFQDNs are FQDNs (or patterns with `*`) outside the cluster domain
+	// which are relevant e.g., aliases served by the CoreDNS `rewrite` plugin
+	ExtraFQDNs []string `json:"extraFQDNs,omitempty"`
+	// IncludePTR makes reverse (PTR) lookups of the pod IP relevant
+	IncludePTR bool `json:"includePTR,omitempty"`
+	// StrictFQDN keeps only the logs where the cluster suffix ends a well-formed FQDN
+	// at a whitespace boundary instead of appearing anywhere in the log (see strictFQDNMatch)
+	StrictFQDN bool `json:"strictFQDN,omitempty"`
+	// ClusterDomain is the domain of the service FQDNs
+	// (detected from the toPod's resolv.conf or the Corefile if empty, see detectClusterDomain)
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+}
+
+// matchesExtraFQDN returns true if the query name in the log matches one of the ExtraFQDNs
+func (f LogFilter) matchesExtraFQDN(rawText string) bool {
+	if len(f.ExtraFQDNs) == 0 {
+		return false
+	}
+
+	qname, _, ok := queryRcode(rawText, f.LogLevelMarker)
+	return ok && matchFQDN(f.ExtraFQDNs, qname)
+}
+
+// matchFQDN returns true if the FQDN matches one of the patterns
+// A pattern can be a FQDN or have `*` wildcards e.g., `*.db.example.com.`
+func matchFQDN(patterns []string, fqdn string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, fqdn); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultLogFilter matches the default log format of the CoreDNS `log` plugin
+var DefaultLogFilter = LogFilter{
+	LogLevelMarker: "[INFO]",
+	RequireNoError: true,
 }
 
 type Runner struct {
 	toPod             *v1.Pod
 	toPodNamespace    string
 	toPodServiceFQDNs []string
+	// toPodServices are the services selecting the toPod (not set with TargetFQDN)
+	toPodServices []v1.Service
+	// toPodHostnameFQDN is the FQDN of the toPod itself through a headless service
+	// e.g., user-db-0.user-db.sock-shop.svc.cluster.local. for a StatefulSet pod
+	toPodHostnameFQDN string
+	// siblingPods are the `<namespace>/<pod-name>` of the pods behind the toPod services
+	// (see relation)
+	siblingPods map[string]bool
+	// workloadPods are the pods of the target workload with InitConfig.WorkloadPodIPs
+	workloadPods []v1.Pod
+	// workloadPodIPs are the IPs of the workloadPods
+	workloadPodIPs map[string]bool
+	// workloadPodFQDNs are the FQDNs resolving to a single one of the workloadPods
+	// i.e., their pod A records and their hostnames through a headless toPod service
+	workloadPodFQDNs []string
 
 	coreDNSPods          *v1.PodList
-	clientset            *kubernetes.Clientset
+	clientset            kubernetes.Interface
 	allNamespaces        *v1.NamespaceList
 	allEndpoints         map[string]*v1.EndpointsList
 	connectionLogs       []*ConnectionLog
@@ -57,20 +184,387 @@ type Runner struct {
 	suggestNetworkPolicy bool
 	concurrency          int
 	waitForLogsDuration  time.Duration
+	// timeoutPerPodLog bounds the wait for the logs of every CoreDNS pod (0 means waitForLogsDuration)
+	timeoutPerPodLog time.Duration
+	output           string
+	podOwners        map[string]*Workload
+	watch            bool
+	onlyNew          bool
+	// silent suppresses printing of the resolved connections
+	silent           bool
+	explain          bool
+	outputDir        string
+	ignoredPodLabels []string
+	// rcodes counts the response codes of the queries for toPodServiceFQDNs
+	rcodes      map[string]int
+	logFilter   LogFilter
+	kubeContext string
+	anonymizer  *Anonymizer
+	logParser   LogParser
+	// toPodIP is set if the toPod was found using its IP
+	toPodIP          string
+	corednsNamespace string
+	corednsSelector  string
+	// corednsFieldSelector narrows down the CoreDNS pods
+	corednsFieldSelector string
+	// podsByIP maps the IPs in allEndpoints to pods
+	podsByIP map[string]*Mapping
+	// podsByIPRefreshed is when podsByIP was last re-indexed by refreshPodsByIP
+	podsByIPRefreshed time.Time
+	policyList        *PolicyList
+	smartDNSEgress    bool
+	dnsServiceName    string
+	// excludedNamespaces are the namespaces whose callers are ignored
+	excludedNamespaces []string
+	// unresolved are the connections from IPs which couldn't be matched to a pod
+	unresolved []Unresolved
+	compact    bool
+	// excludedPods are the pods (`<pod-name>` or `<namespace>/<pod-name>`) ignored as callers
+	excludedPods []string
+	// excludedPodSelector selects the pods ignored as callers (nil means none)
+	excludedPodSelector labels.Selector
+	// excludedCallers caches the result of matching excludedPodSelector
+	excludedCallers map[string]bool
+	onConnection    func(*ConnectionLog, *Mapping)
+	policyOnly      bool
+	// nodesByIP maps the node IPs to the node names (set with DetectHostNetwork)
+	nodesByIP map[string]string
+	// hostNetworkPods are the host network pods per node
+	hostNetworkPods map[string][]string
+	sink            *Sink
+	watchMode       string
+	pollInterval    time.Duration
+	// largeResponse notes the FQDNs with responses over largeResponseSize
+	largeResponse  bool
+	largeResponses map[string]*largeResponses
+	// loki is where the CoreDNS logs are read from (nil means the CoreDNS pods)
+	loki *Loki
+	// failOnUnresolved returns ErrUnresolvedCallers from Run if there are unresolved callers
+	failOnUnresolved bool
+	// logFiles are where the CoreDNS logs are read from instead of the CoreDNS pods (see LogFiles)
+	logFiles []string
+	// linesScanned is the number of log lines scanned per CoreDNS pod
+	linesScanned map[string]int
+	// logsUntil is when the logs were read
+	logsUntil time.Time
+	// lastLogTimes is the time of the last log line read per CoreDNS pod
+	// (or when its logs were read if there were none) so that watching
+	// resumes from there without missing the logs written in between
+	lastLogTimes map[string]time.Time
+	// lastLogLines is the last log line read per CoreDNS pod so that a poll
+	// skips the lines read before if the logs are read without timestamps
+	lastLogLines map[string]string
+	auditFile    string
+	// dumpResources is where the fetched resources are written (not written if empty)
+	dumpResources string
+	// rawLogs are the CoreDNS logs per CoreDNS pod (nil unless DumpLogs)
+	rawLogs map[string][]string
+	// anyConnection prints only whether there is an incoming connection
+	anyConnection bool
+	// stopWatching stops watchConnectionLogs (set while watching)
+	stopWatching context.CancelFunc
+	// fromPod is the only caller looked for (nil means all the callers)
+	fromPod *v1.Pod
+	// ctx cancels waiting for and following the logs (see InitConfig.Ctx)
+	ctx context.Context
+	// policyAPIVersion is the apiVersion of the suggested NetworkPolicies
+	policyAPIVersion string
+	// resolveServices prints every service a caller used (see printServicesByCaller)
+	resolveServices bool
+	// groupBy splits the connections in the output e.g., GroupByProtocol (empty means not split)
+	groupBy string
+	// verifyPolicy is verified against the callers instead of printing them (see printPolicyVerification)
+	verifyPolicy *networkingv1.NetworkPolicy
+	// config is used for exec into the toPod (see PodClusterDomain)
+	config *rest.Config
+	// color highlights the pods, namespaces and services in the text output
+	color bool
+	// stopAtFirstConnection stops watchConnectionLogs at the first new connection
+	stopAtFirstConnection bool
+	// waitForConnection is how long to follow the logs for the first connection
+	// if there is none in the existing logs (0 means not waiting)
+	waitForConnection time.Duration
+	// policyGranularity is how the peers are split into NetworkPolicies
+	policyGranularity string
+	// targetAllServices finds the toPod services by their Endpoints (see endpointsHavePod)
+	targetAllServices bool
+	// showCommands prints the kubectl equivalents of the K8s API calls (see kubectlCommands)
+	showCommands bool
+	// minimalPeerLabels selects the peers by their minimal labels (see minimalLabels)
+	minimalPeerLabels bool
+	labelKeyPriority  []string
+	// namespacePods caches the pods per namespace for minimalLabels
+	namespacePods map[string][]v1.Pod
+	// warnings are collected for embedders (see Warnings)
+	warnings   []Warning
+	warningsMu sync.Mutex
+	// analyzed is set once the connection logs were processed by Analyze or SuggestedNetworkPolicy
+	analyzed bool
+	// clusterDomainSource is where the cluster domain was detected from
+	// One of: ClusterDomainFromResolvConf, ClusterDomainFromCorefile (empty if it wasn't detected)
+	clusterDomainSource string
+	// singlePass parses the logs while waiting for them (see followConnectionLogs)
+	singlePass bool
+	// peerTTL is how long a caller is kept without being seen in watch mode (0 means forever)
+	peerTTL time.Duration
+	// cache is used to look up the caller pods for OutputWideJSON
+	cache *Cache
+	// mergeSubsetPeers collapses the peers whose labels are a superset of another peer's labels
+	mergeSubsetPeers bool
+	// maxPeers is the maximum number of peers in the suggested NetworkPolicy (0 means no limit)
+	maxPeers int
+	// outputNamespaces prints the number of callers per namespace
+	outputNamespaces bool
+	interactive      bool
+	// excludedPeers are the pod selectors of the peers
+	// the user excluded from the suggested NetworkPolicy
+	excludedPeers []string
+	// includeIPBlocks allows the unresolved callers as ipBlock peers (see ipBlockPeers)
+	includeIPBlocks bool
+	// ipBlockPrefixLength is the prefix length of the IPv4 ipBlock peers (0 means 32)
+	ipBlockPrefixLength int
 }
 
+// Mapping is a caller pod resolved from a connection log
 type Mapping struct {
 	podname   string
 	namespace string
+	// queryID and transport are of the first query seen from the pod
+	queryID   string
+	transport string
+	// lastSeen is when a query from the pod was last processed
+	lastSeen time.Time
+	// count is the number of queries processed from the pod
+	count int
+	// samples are the first log lines of the queries from the pod (see explainSampleLogs)
+	samples []string
+	// transports counts the queries from the pod per transport
+	transports map[string]int
+	// lastQueried is when the latest query from the pod was logged (zero if unknown)
+	lastQueried time.Time
+}
+
+// PodName returns the name of the caller pod
+func (m *Mapping) PodName() string {
+	return m.podname
+}
+
+// Namespace returns the namespace of the caller pod
+func (m *Mapping) Namespace() string {
+	return m.namespace
 }
 
 type InitConfig struct {
-	ToPodName            string
-	ToPodNamespace       string
+	// ToPodName is a pod name or `<kind>/<name>` of a workload e.g., `job/my-batch`
+	ToPodName      string
+	ToPodNamespace string
+	// ToPodIP is used to find the toPod when ToPodName is not known
+	ToPodIP              string
 	Config               *rest.Config
 	SuggestNetworkPolicy bool
 	Concurrency          int
 	WaitForLogsDuration  time.Duration
+	// TimeoutPerPodLog bounds the wait for the logs of every CoreDNS pod
+	// within WaitForLogsDuration (0 means WaitForLogsDuration)
+	TimeoutPerPodLog time.Duration
+	// Output is the output format: OutputText (default), OutputWide, OutputTable, OutputJSON, OutputWideJSON, OutputYAML or OutputMarkdown
+	Output string
+	// Watch keeps following the CoreDNS logs for new connections
+	Watch bool
+	// OnlyNew prints only the connections which were not seen
+	// in the initial logs (requires Watch)
+	OnlyNew bool
+	// Explain annotates each peer in the suggested NetworkPolicy
+	// with the pods and services it was created from
+	Explain bool
+	// OutputDir is where `<pod-name>.connections.json` and
+	// `<pod-name>.policy.yaml` are written (not written if empty)
+	OutputDir string
+	// IgnoredPodLabels are not used in the suggested NetworkPolicy
+	// (defaults to DefaultIgnoredPodLabels if nil)
+	IgnoredPodLabels []string
+	// LogFilter decides which CoreDNS logs are relevant
+	// (defaults to DefaultLogFilter if nil)
+	LogFilter *LogFilter
+	// CoreDNSNamespace is where the CoreDNS pods run
+	// (defaults to DefaultCoreDNSNamespace if empty)
+	CoreDNSNamespace string
+	// CoreDNSSelector is the label selector of the CoreDNS pods
+	// (defaults to DefaultCoreDNSSelector if empty)
+	CoreDNSSelector string
+	// CoreDNSFieldSelector narrows down the CoreDNS pods e.g., `status.phase=Running`
+	CoreDNSFieldSelector string
+	// Context is the kubeconfig context of Config
+	// It is used only to label the output
+	Context string
+	// Anonymizer anonymizes the names in the output (nil means no anonymization)
+	Anonymizer *Anonymizer
+	// LogParser parses the DNS logs (defaults to CoreDNSLogParser using LogFilter)
+	LogParser LogParser
+	// PolicyList collects the suggested NetworkPolicy instead of printing it
+	PolicyList *PolicyList
+	// ResolveStalePod uses a current pod of the workload the ToPodName pod
+	// belonged to if the pod doesn't exist anymore e.g., after a rollout
+	ResolveStalePod bool
+	// SmartDNSEgress also suggests a NetworkPolicy allowing DNS egress to CoreDNS
+	// for the calling workloads whose existing egress NetworkPolicies don't allow it
+	SmartDNSEgress bool
+	// DNSServiceName is the service in CoreDNSNamespace the DNS egress rule points to
+	// (defaults to DefaultDNSServiceName if empty)
+	DNSServiceName string
+	// ExcludedNamespaces are the namespaces whose callers are ignored
+	// in both the connections and the suggested NetworkPolicy
+	ExcludedNamespaces []string
+	// TargetFQDN (with the trailing dot) is the only FQDN used for matching
+	// instead of the FQDNs of the services selecting the pod
+	TargetFQDN string
+	// Compact prints only a single line summary of the callers
+	Compact bool
+	// ExcludedPods are the pods (`<pod-name>` or `<namespace>/<pod-name>`)
+	// ignored as callers e.g., a monitoring pod which queries every service
+	ExcludedPods []string
+	// ExcludedPodSelector is the label selector of the pods ignored as callers
+	ExcludedPodSelector string
+	// OnConnection is called for every new caller as soon as it is resolved
+	// (including the ones found while watching) e.g., to render progress in a UI
+	// It is called sequentially, so it shouldn't block
+	OnConnection func(*ConnectionLog, *Mapping)
+	// PolicyOnly prints only the suggested NetworkPolicy (requires SuggestNetworkPolicy)
+	PolicyOnly bool
+	// OutputNamespaces prints a summary of the callers per namespace
+	OutputNamespaces bool
+	// Interactive asks the user on stdin which peers to include
+	// in the suggested NetworkPolicy (requires SuggestNetworkPolicy)
+	Interactive bool
+	// Cache reuses the namespaces, endpoints and CoreDNS pods
+	// across the runners of the same cluster (nil means no caching)
+	Cache *Cache
+	// DetectHostNetwork reports the connections from node IPs as host network
+	// connections along with the host network pods on the node
+	// instead of matching them to a pod
+	DetectHostNetwork bool
+	// Sink receives every new connection (nil means no sink)
+	Sink *Sink
+	// LargeResponse prints the FQDNs of the pod with responses
+	// too large for UDP without EDNS0 (likely falling back to TCP)
+	LargeResponse bool
+	// DumpResources is where the resources fetched for the pod
+	// are written as a YAML ResourceBundle (not written if empty)
+	DumpResources string
+	// DumpLogs adds the raw CoreDNS logs to the ResourceBundle
+	DumpLogs bool
+	// AuditFile is where the evidence behind the suggested NetworkPolicy
+	// is written as JSON (not written if empty)
+	AuditFile string
+	// Loki reads the CoreDNS logs from Loki instead of the CoreDNS pods (nil means the pods)
+	Loki *Loki
+	// FailOnUnresolved makes Run return ErrUnresolvedCallers if any caller IP
+	// couldn't be resolved to a pod e.g., for strict audits of unexplained traffic
+	FailOnUnresolved bool
+	// LogFiles reads the CoreDNS logs from the files instead of the CoreDNS pods (empty means the pods)
+	// `.gz` files and `.tar.gz` archives of log files are decompressed (see openLogFiles)
+	LogFiles []string
+	// FromPodName is `<pod-name>` (in the namespace of the toPod) or `<namespace>/<pod-name>`
+	// of a pod. If set, only whether the pod connects to the toPod is printed
+	FromPodName string
+	// VerifyPolicy is a NetworkPolicy (e.g., read with ReadNetworkPolicy) to verify against
+	// the callers. If set, only which callers it allows or blocks is printed
+	VerifyPolicy *networkingv1.NetworkPolicy
+	// FQDNAnnotation is the key of the annotation of the toPod with its comma separated FQDNs
+	// e.g., the canonical service names. If the toPod has the annotation, its FQDNs are used
+	// instead of the FQDNs of the services selecting the toPod
+	FQDNAnnotation string
+	// WorkloadPodIPs counts the queries for any pod of the target workload (e.g., `deployment/user-db`)
+	// as incoming connections i.e., for its pod A record, its hostname through a headless service
+	// or a reverse (PTR) lookup of its IP (with LogFilter.IncludePTR), not only for the analyzed pod
+	WorkloadPodIPs bool
+	// IncludeIPBlocks allows the callers which couldn't be resolved to pods (see Unresolved)
+	// as ipBlock peers in a separate ingress rule of the suggested NetworkPolicy
+	IncludeIPBlocks bool
+	// IPBlockPrefixLength summarizes the IPv4 ipBlock peers of IncludeIPBlocks
+	// into CIDRs with the prefix length e.g., 24 (0 means a /32 per IP)
+	IPBlockPrefixLength int
+	// PolicyAPIVersion is the apiVersion of the suggested NetworkPolicies, one of PolicyAPIVersions
+	// (defaults to PolicyAPIVersionV1 if empty)
+	PolicyAPIVersion string
+	// ResolveServices prints every service each caller used to reach the toPod
+	// after the connections in the text and table outputs
+	ResolveServices bool
+	// GroupBy splits the connections in the text, table and JSON outputs
+	// e.g., GroupByProtocol (empty means not split)
+	GroupBy string
+	// Ctx cancels waiting for and following the logs e.g., on Ctrl-C. The connections
+	// found so far are still printed (defaults to context.Background() if nil)
+	Ctx context.Context
+	// Color highlights the pods, namespaces and services in the text
+	// and wide output with ANSI colors e.g., if stdout is a terminal
+	Color bool
+	// WaitForConnection follows the logs up to WaitForConnection for the first
+	// connection to the pod if there is none in the existing logs (0 means not waiting)
+	WaitForConnection time.Duration
+	// PolicyGranularity is how the peers are split into the suggested NetworkPolicies
+	// One of: PolicyGranularitySingle (default), PolicyGranularityWorkload
+	PolicyGranularity string
+	// SinglePass reads the logs of the CoreDNS pods once, parsing them while
+	// waiting for the relevant logs instead of reading them again after waiting
+	SinglePass bool
+	// AnyConnection prints only `yes` or `no` depending on whether there is
+	// an incoming connection. With Watch, it stops at the first connection
+	AnyConnection bool
+	// PeerTTL removes a caller not seen for PeerTTL while watching
+	// so that it isn't used in the suggested NetworkPolicy (0 means never)
+	PeerTTL time.Duration
+	// WatchMode is WatchModeStream (default) or WatchModePoll
+	WatchMode string
+	// PollInterval is the interval between the polls of WatchModePoll
+	PollInterval time.Duration
+	// MergeSubsetPeers collapses every peer whose labels are a superset
+	// of another peer's labels into that peer. This can widen the policy
+	MergeSubsetPeers bool
+	// MaxPeers is the maximum number of peers in the suggested NetworkPolicy
+	// The NetworkPolicy is not suggested if it has more peers (0 means no limit)
+	MaxPeers int
+	// MaxCoreDNSPods is the maximum number of CoreDNS pods whose logs are read
+	// (0 means all). The first pods by name are read
+	MaxCoreDNSPods int
+	// TargetAllServices finds the services of the toPod by their Endpoints
+	// having the toPod IP instead of by their selectors
+	TargetAllServices bool
+	// ShowCommands prints the kubectl equivalents of the K8s API calls
+	ShowCommands bool
+	// MinimalPeerLabels selects every peer of the suggested NetworkPolicy only by
+	// the fewest labels which select just the pods of its workload in its namespace
+	MinimalPeerLabels bool
+	// LabelKeyPriority is the order in which the label keys are tried for MinimalPeerLabels
+	// (defaults to DefaultLabelKeyPriority if nil)
+	LabelKeyPriority []string
+}
+
+// ErrUnresolvedCallers is returned by Run with FailOnUnresolved
+// if any caller IP couldn't be resolved to a pod (see Unresolved)
+type ErrUnresolvedCallers struct {
+	Pod string
+	// Callers describe the unresolved callers
+	// e.g., `ip: 203.0.113.7 (unresolved) via svc: user-db.sock-shop.svc.cluster.local.`
+	Callers []string
+}
+
+func (e *ErrUnresolvedCallers) Error() string {
+	return fmt.Sprintf("%d caller IP(s) of pod %s couldn't be resolved to pods:\n%s", len(e.Callers), e.Pod, strings.Join(e.Callers, "\n"))
+}
+
+// ErrNoDNSPods is returned when no CoreDNS pods are found
+type ErrNoDNSPods struct {
+	Namespace     string
+	Selector      string
+	FieldSelector string
+}
+
+func (e *ErrNoDNSPods) Error() string {
+	if e.FieldSelector != "" {
+		return fmt.Sprintf("no CoreDNS pods found in namespace `%s` with label selector `%s` and field selector `%s`", e.Namespace, e.Selector, e.FieldSelector)
+	}
+	return fmt.Sprintf("no CoreDNS pods found in namespace `%s` with label selector `%s`", e.Namespace, e.Selector)
 }
 
 func init() {
@@ -92,73 +586,450 @@ func Initialize(ic *InitConfig) (interfaces.RunnerInterface, error) {
 		return nil, err
 	}
 
-	toPod, err := clientset.CoreV1().Pods(ic.ToPodNamespace).Get(context.Background(), ic.ToPodName, metav1.GetOptions{})
+	// warnings found before the Runner exists
+	warnings := []Warning{}
+
+	// the namespace of the toPod found by its IP isn't written back to ic
+	toPodNamespace := ic.ToPodNamespace
+	var toPod *v1.Pod
+	// targetWorkload is set if the target is a workload e.g., `deployment/user-db`
+	var targetWorkload *Workload
+	if ic.ToPodIP != "" {
+		toPod, err = findPodByIP(clientset, ic.ToPodIP)
+		if err != nil {
+			return nil, err
+		}
+		toPodNamespace = toPod.Namespace
+		log.Infof("pod: %s, ns: %s has IP %s\n", ic.Anonymizer.Pod(toPod.Name), ic.Anonymizer.Namespace(toPod.Namespace), ic.ToPodIP)
+	} else if kind, name, ok := strings.Cut(ic.ToPodName, "/"); ok {
+		var w *Workload
+		toPod, w, err = findWorkloadPod(clientset, toPodNamespace, kind, name)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("using pod %s of %s\n", ic.Anonymizer.Pod(toPod.Name), ic.Anonymizer.workload(*w))
+		targetWorkload = w
+	} else {
+		toPod, err = clientset.CoreV1().Pods(toPodNamespace).Get(context.Background(), ic.ToPodName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) && ic.ResolveStalePod {
+			var w *Workload
+			toPod, w, err = findCurrentWorkloadPod(clientset, toPodNamespace, ic.ToPodName)
+			if err != nil {
+				return nil, fmt.Errorf("pod %s not found and %v", ic.ToPodName, err)
+			}
+			log.Warnf("pod %s not found, using pod %s of %s instead\n", ic.Anonymizer.Pod(ic.ToPodName), ic.Anonymizer.Pod(toPod.Name), ic.Anonymizer.workload(*w))
+			warnings = append(warnings, Warning{
+				Code:    WarningStalePod,
+				Message: fmt.Sprintf("pod %s not found, using pod %s of %s instead", ic.Anonymizer.Pod(ic.ToPodName), ic.Anonymizer.Pod(toPod.Name), ic.Anonymizer.workload(*w)),
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nsList, allEps, err := ic.Cache.namespacesEndpoints(clientset, ic.Concurrency)
 	if err != nil {
 		return nil, err
 	}
 
-	nsList, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	corednsNamespace := ic.CoreDNSNamespace
+	if corednsNamespace == "" {
+		corednsNamespace = DefaultCoreDNSNamespace
+	}
+	corednsSelector := ic.CoreDNSSelector
+	if corednsSelector == "" {
+		corednsSelector = DefaultCoreDNSSelector
+	}
+
+	podList, err := ic.Cache.podList(clientset, corednsNamespace, corednsSelector, ic.CoreDNSFieldSelector)
 	if err != nil {
 		return nil, err
 	}
+	if len(podList.Items) == 0 {
+		return nil, &ErrNoDNSPods{Namespace: corednsNamespace, Selector: corednsSelector, FieldSelector: ic.CoreDNSFieldSelector}
+	}
+	if ic.MaxCoreDNSPods > 0 && len(podList.Items) > ic.MaxCoreDNSPods {
+		msg := fmt.Sprintf("reading the logs of %d out of %d CoreDNS pods (--max-coredns-pods), connections served only by the other pods are missed", ic.MaxCoreDNSPods, len(podList.Items))
+		log.Warn(msg)
+		warnings = append(warnings, Warning{Code: WarningSampledCoreDNSPods, Message: msg})
+		podList = firstPods(podList, ic.MaxCoreDNSPods)
+	}
 
-	allEps := map[string]*v1.EndpointsList{}
-	for _, n := range nsList.Items {
-		eList, err := clientset.CoreV1().Endpoints(n.Name).List(context.Background(), metav1.ListOptions{})
+	r := &Runner{
+		toPod:                 toPod,
+		toPodNamespace:        toPodNamespace,
+		coreDNSPods:           podList,
+		clientset:             clientset,
+		config:                ic.Config,
+		allNamespaces:         nsList,
+		allEndpoints:          allEps,
+		hostnamePodMapping:    map[string][]*Mapping{},
+		suggestNetworkPolicy:  ic.SuggestNetworkPolicy,
+		concurrency:           ic.Concurrency,
+		waitForLogsDuration:   ic.WaitForLogsDuration,
+		timeoutPerPodLog:      ic.TimeoutPerPodLog,
+		output:                ic.Output,
+		podOwners:             map[string]*Workload{},
+		watch:                 ic.Watch,
+		onlyNew:               ic.OnlyNew,
+		explain:               ic.Explain,
+		outputDir:             ic.OutputDir,
+		ignoredPodLabels:      ic.IgnoredPodLabels,
+		rcodes:                map[string]int{},
+		logFilter:             DefaultLogFilter,
+		kubeContext:           ic.Context,
+		anonymizer:            ic.Anonymizer,
+		toPodIP:               ic.ToPodIP,
+		corednsNamespace:      corednsNamespace,
+		corednsSelector:       corednsSelector,
+		corednsFieldSelector:  ic.CoreDNSFieldSelector,
+		policyList:            ic.PolicyList,
+		smartDNSEgress:        ic.SmartDNSEgress,
+		dnsServiceName:        ic.DNSServiceName,
+		excludedNamespaces:    ic.ExcludedNamespaces,
+		compact:               ic.Compact,
+		excludedPods:          ic.ExcludedPods,
+		excludedCallers:       map[string]bool{},
+		onConnection:          ic.OnConnection,
+		policyOnly:            ic.PolicyOnly,
+		sink:                  ic.Sink,
+		cache:                 ic.Cache,
+		watchMode:             ic.WatchMode,
+		pollInterval:          ic.PollInterval,
+		peerTTL:               ic.PeerTTL,
+		anyConnection:         ic.AnyConnection,
+		stopAtFirstConnection: ic.AnyConnection,
+		waitForConnection:     ic.WaitForConnection,
+		singlePass:            ic.SinglePass,
+		policyGranularity:     ic.PolicyGranularity,
+		showCommands:          ic.ShowCommands,
+		targetAllServices:     ic.TargetAllServices,
+		verifyPolicy:          ic.VerifyPolicy,
+		ctx:                   ic.Ctx,
+		groupBy:               ic.GroupBy,
+		resolveServices:       ic.ResolveServices,
+		policyAPIVersion:      ic.PolicyAPIVersion,
+		minimalPeerLabels:     ic.MinimalPeerLabels,
+		labelKeyPriority:      ic.LabelKeyPriority,
+		color:                 ic.Color,
+		loki:                  ic.Loki,
+		logFiles:              ic.LogFiles,
+		failOnUnresolved:      ic.FailOnUnresolved,
+		linesScanned:          map[string]int{},
+		lastLogTimes:          map[string]time.Time{},
+		lastLogLines:          map[string]string{},
+		auditFile:             ic.AuditFile,
+		dumpResources:         ic.DumpResources,
+		largeResponse:         ic.LargeResponse,
+		largeResponses:        map[string]*largeResponses{},
+		maxPeers:              ic.MaxPeers,
+		mergeSubsetPeers:      ic.MergeSubsetPeers,
+		outputNamespaces:      ic.OutputNamespaces,
+		interactive:           ic.Interactive,
+		includeIPBlocks:       ic.IncludeIPBlocks,
+		ipBlockPrefixLength:   ic.IPBlockPrefixLength,
+	}
+
+	if r.policyAPIVersion == "" {
+		r.policyAPIVersion = PolicyAPIVersionV1
+	}
+	if !contains(PolicyAPIVersions, r.policyAPIVersion) {
+		return nil, fmt.Errorf("unsupported NetworkPolicy apiVersion `%s` (supported: %s)", r.policyAPIVersion, strings.Join(PolicyAPIVersions, ", "))
+	}
+
+	if ic.ExcludedPodSelector != "" {
+		r.excludedPodSelector, err = labels.Parse(ic.ExcludedPodSelector)
 		if err != nil {
+			return nil, fmt.Errorf("invalid pod selector `%s`: %w", ic.ExcludedPodSelector, err)
+		}
+	}
+
+	r.warnings = warnings
+
+	if r.ctx == nil {
+		r.ctx = context.Background()
+	}
+
+	if ic.LogFilter != nil {
+		r.logFilter = *ic.LogFilter
+	}
+	if r.logFilter.ClusterDomain == "" {
+		r.logFilter.ClusterDomain, r.clusterDomainSource = r.detectClusterDomain()
+	}
+
+	var fqdnsFromAnnotation []string
+	if ic.FQDNAnnotation != "" {
+		fqdnsFromAnnotation = annotationFQDNs(toPod, ic.FQDNAnnotation)
+		if len(fqdnsFromAnnotation) == 0 {
+			log.Infof("pod %s has no annotation %s, finding its services instead", ic.Anonymizer.Pod(toPod.Name), ic.FQDNAnnotation)
+		}
+		// FQDNs outside the cluster domain are only relevant as ExtraFQDNs
+		extra := append([]string{}, r.logFilter.ExtraFQDNs...)
+		for _, f := range fqdnsFromAnnotation {
+			if !strings.HasSuffix(f, r.logFilter.fqdnSuffix()) {
+				extra = append(extra, f)
+			}
+		}
+		r.logFilter.ExtraFQDNs = extra
+	}
+
+	if ic.WorkloadPodIPs {
+		if targetWorkload == nil {
+			return nil, fmt.Errorf("matching the IPs of the pods of a workload requires a workload e.g., deployment/user-db")
+		}
+		if err := r.indexWorkloadPods(targetWorkload); err != nil {
 			return nil, err
 		}
-		allEps[n.Name] = eList
+		// the pod A records are outside the service domain
+		r.logFilter.ExtraFQDNs = append(append([]string{}, r.logFilter.ExtraFQDNs...), r.workloadPodFQDNs...)
 	}
 
-	ctx := context.Background()
-	podList, err := clientset.CoreV1().Pods(corednsNamespace).List(ctx, metav1.ListOptions{
-		LabelSelector: corednsPodLabels,
-	})
-	if err != nil {
-		return nil, err
+	r.logParser = ic.LogParser
+	if r.logParser == nil {
+		r.logParser = &CoreDNSLogParser{Filter: r.logFilter}
 	}
 
-	r := &Runner{
-		toPod:                toPod,
-		toPodNamespace:       ic.ToPodNamespace,
-		coreDNSPods:          podList,
-		clientset:            clientset,
-		allNamespaces:        nsList,
-		allEndpoints:         allEps,
-		hostnamePodMapping:   map[string][]*Mapping{},
-		suggestNetworkPolicy: ic.SuggestNetworkPolicy,
-		concurrency:          ic.Concurrency,
-		waitForLogsDuration:  ic.WaitForLogsDuration,
-	}
-
-	toPodServiceFQDNs, err := r.findToPodServiceFQDNs()
-	if err != nil {
-		return nil, err
+	if r.dnsServiceName == "" {
+		r.dnsServiceName = DefaultDNSServiceName
 	}
 
-	r.toPodServiceFQDNs = toPodServiceFQDNs
+	if r.ignoredPodLabels == nil {
+		r.ignoredPodLabels = DefaultIgnoredPodLabels
+	}
 
-	if err := r.waitForLogs(); err != nil {
-		return nil, err
+	if r.labelKeyPriority == nil {
+		r.labelKeyPriority = DefaultLabelKeyPriority
 	}
 
-	connLogList, err := r.parseConnectionLogs()
-	if err != nil {
-		return nil, err
+	if r.output == "" {
+		r.output = OutputText
+	}
+
+	r.indexPodsByIP()
+
+	if ic.FromPodName != "" {
+		if err := r.findFromPod(ic.FromPodName); err != nil {
+			return nil, err
+		}
+	}
+
+	if ic.DetectHostNetwork {
+		r.nodesByIP, r.hostNetworkPods, err = indexNodes(clientset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ic.TargetFQDN != "" {
+		// skip the service discovery
+		r.toPodServiceFQDNs = []string{ic.TargetFQDN}
+	} else if len(fqdnsFromAnnotation) > 0 {
+		log.Infof("using the FQDNs in annotation %s of the pod: %s", ic.FQDNAnnotation, strings.Join(fqdnsFromAnnotation, ", "))
+		r.toPodServiceFQDNs = fqdnsFromAnnotation
+	} else {
+		toPodServiceFQDNs, err := r.findToPodServiceFQDNs()
+		if err != nil {
+			return nil, err
+		}
+
+		r.toPodServiceFQDNs = toPodServiceFQDNs
+		r.addWorkloadPodHostnames()
+	}
+
+	if ic.DumpLogs {
+		r.rawLogs = map[string][]string{}
+	}
+
+	var connLogList []*ConnectionLog
+	if r.readsPodLogs() && r.singlePass {
+		connLogList, err = r.followConnectionLogs()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if r.readsPodLogs() {
+			// Loki and the log files have the history, there is nothing to wait for
+			if err := r.waitForLogs(); err != nil {
+				return nil, err
+			}
+		}
+
+		connLogList, err = r.parseConnectionLogs(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.logsUntil = time.Now()
+	if r.ctx.Err() != nil {
+		r.warnf(WarningInterrupted, "interrupted while waiting for the logs, the results are based on the logs read so far and may be partial")
 	}
 
 	r.connectionLogs = connLogList
+	if len(connLogList) == 0 && len(r.logFiles) > 0 {
+		r.warnf(WarningNoRelevantLogs, "no relevant logs in the log files %s", strings.Join(r.logFiles, ", "))
+	} else if len(connLogList) == 0 {
+		r.warnNoRelevantLogs()
+	}
 
 	return r, nil
 }
 
+// Run prints the connections (and what else is asked for) of the toPod
+// With FailOnUnresolved, it returns ErrUnresolvedCallers after printing them if any caller is unresolved
 func (r *Runner) Run() error {
-	fmt.Println("INCOMING CONNECTIONS")
-	fmt.Println("--------------------")
+	if err := r.run(); err != nil {
+		return err
+	}
+
+	if r.failOnUnresolved && len(r.unresolved) > 0 {
+		callers := []string{}
+		for _, u := range r.unresolved {
+			callers = append(callers, fmt.Sprintf("ip: %s %s via svc: %s", u.IP, r.describeUnresolved(u), r.anonymizer.fqdn(u.ToFQDN)))
+		}
+		sort.Strings(callers)
+		return &ErrUnresolvedCallers{Pod: r.anonymizer.Pod(r.toPod.Name), Callers: callers}
+	}
+	return nil
+}
+
+func (r *Runner) run() error {
+	if r.showCommands {
+		r.printKubectlCommands()
+	}
+
+	if TextOutput(r.output) && !r.onlyNew && !r.compact && !r.policyOnly && !r.anyConnection && r.fromPod == nil && r.verifyPolicy == nil {
+		r.printMultipleServices()
+		printBanner("INCOMING CONNECTIONS")
+	}
+
+	// seed the known connections without printing them
+	// the connections grouped by protocol are printed once all of them are known
+	// so are the connections in the wide output because of their last seen age
+	r.silent = r.onlyNew || r.compact || r.policyOnly || r.anyConnection || r.fromPod != nil || r.verifyPolicy != nil || r.groupBy == GroupByProtocol || r.output == OutputWide
 	if err := r.processConnectionLogs(); err != nil {
 		return err
 	}
+	if r.waitForConnection > 0 && len(r.hostnamePodMapping) == 0 {
+		if err := r.awaitConnection(); err != nil {
+			return err
+		}
+	}
+	r.silent = r.anyConnection || r.fromPod != nil || r.verifyPolicy != nil
+
+	if r.dumpResources != "" {
+		if err := r.writeResourceBundle(r.dumpResources); err != nil {
+			return err
+		}
+	}
+
+	if r.anyConnection {
+		return r.printAnyConnection()
+	}
+
+	if r.fromPod != nil {
+		return r.printFromPodConnections()
+	}
+
+	if r.verifyPolicy != nil {
+		return r.printPolicyVerification()
+	}
+
+	if r.output == OutputTable && !r.compact && !r.policyOnly {
+		r.printConnectionsTable()
+	}
+	if r.output == OutputWide && r.groupBy != GroupByProtocol && !r.onlyNew && !r.compact && !r.policyOnly {
+		r.printWideConnections()
+	}
+	if r.groupBy == GroupByProtocol && (r.output == OutputText || r.output == OutputWide) && !r.compact && !r.policyOnly {
+		r.printConnectionsByProtocol()
+	}
+	if r.resolveServices && TextOutput(r.output) && !r.compact && !r.policyOnly {
+		r.printServicesByCaller()
+	}
+
+	if r.watch {
+		if TextOutput(r.output) {
+			printBanner("WATCHING FOR NEW CONNECTIONS")
+		}
+		return r.watchConnectionLogs(r.ctx)
+	}
+
+	edges, err := r.workloadEdges()
+	if err != nil {
+		return err
+	}
+
+	if r.suggestNetworkPolicy && r.interactive {
+		if err := r.selectPeers(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	if r.outputDir != "" {
+		if err := r.writeOutputDir(edges); err != nil {
+			return err
+		}
+	}
+
+	if r.auditFile != "" {
+		if err := r.writeAudit(r.auditFile); err != nil {
+			return err
+		}
+	}
+
+	if r.suggestNetworkPolicy && r.policyList != nil {
+		nps, _, err := r.suggestedNetPols()
+		if err != nil {
+			return err
+		}
+		for _, n := range nps {
+			r.policyList.Add(n)
+		}
+
+		if r.smartDNSEgress {
+			nps, err := r.dnsEgressNetPols()
+			if err != nil {
+				return err
+			}
+			for _, n := range nps {
+				r.policyList.Add(n)
+			}
+		}
+	}
+
+	if r.compact {
+		r.printCompact()
+		return nil
+	}
+
+	if r.policyOnly {
+		if r.policyList != nil {
+			// printed later along with the NetworkPolicies of other pods
+			return nil
+		}
+		return r.printPolicyOnly()
+	}
+
+	if r.output == OutputJSON || r.output == OutputWideJSON {
+		return r.writeJSON(os.Stdout, edges)
+	}
+	if r.output == OutputYAML {
+		return r.writeYAML(os.Stdout, edges)
+	}
+	if r.output == OutputMarkdown {
+		return r.writeMarkdown(os.Stdout)
+	}
+
+	r.printWorkloadEdges(edges)
+	r.printDistribution()
+	if r.outputNamespaces {
+		r.printFanInByNamespace()
+	}
+	printDNSHealth(r.rcodes)
+	if r.largeResponse {
+		r.printLargeResponses()
+	}
 
 	if r.suggestNetworkPolicy {
 		return r.suggestNetPol()
@@ -168,78 +1039,155 @@ func (r *Runner) Run() error {
 
 // waitForLogs waits for the connection logs to show up
 // in coredns pods
+// The wait is bounded by waitForLogsDuration overall and
+// by timeoutPerPodLog per pod (see podLogContext)
 func (r *Runner) waitForLogs() error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.waitForLogsDuration)
+	defer cancel()
+
 	var wg sync.WaitGroup
 	var e error
 	var mu sync.Mutex
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var wg2 sync.WaitGroup
-
-		for _, pod := range r.coreDNSPods.Items {
-			wg2.Add(1)
-			// why? check
-			// 1. https://github.com/golang/go/wiki/CommonMistakes#using-reference-to-loop-iterator-variable
-			// 2. https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
-			pod := pod
-			go func() {
-				tStart := time.Now()
-				defer wg2.Done()
-				ctx2 := context.Background()
-				tailLines := new(int64)
-				*tailLines = 5
-				req := r.clientset.CoreV1().Pods("kube-system").GetLogs(pod.Name, &v1.PodLogOptions{Follow: true, TailLines: tailLines})
-				stream, err := req.Stream(ctx2)
-				if err != nil {
-					mu.Lock()
-					log.Errorf(logNotFound, pod.Name, r.waitForLogsDuration)
-					e = err
-					mu.Unlock()
+	for _, pod := range r.coreDNSPods.Items {
+		wg.Add(1)
+		// why? check
+		// 1. https://github.com/golang/go/wiki/CommonMistakes#using-reference-to-loop-iterator-variable
+		// 2. https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		pod := pod
+		go func() {
+			defer wg.Done()
+			podCtx, podCancel, timeout := r.podLogContext(ctx)
+			defer podCancel()
+
+			tailLines := new(int64)
+			*tailLines = 5
+			req := r.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{Follow: true, TailLines: tailLines})
+			stream, err := req.Stream(podCtx)
+			if err != nil {
+				if r.ctx.Err() != nil {
+					// interrupted, the logs so far are read anyway
+					return
 				}
-				defer stream.Close()
-
-				scanner := bufio.NewScanner(stream)
-				// scanner has a limitation where it can read max 65536 characters
-				// More info and solution: https://stackoverflow.com/a/16615559/6874596
-
-				log.Debugf("%s: looking for relevant logs in the coredns pod logs\n", pod.Name)
-				for scanner.Scan() {
-					t := scanner.Text()
-					tEnd := time.Now()
-					if tEnd.Sub(tStart) > r.waitForLogsDuration {
-						log.Infof("%s: giving up... :(\n", pod.Name)
-
-						mu.Lock()
-						log.Errorf(logNotFound, pod.Name, r.waitForLogsDuration)
-						e = fmt.Errorf(logNotFound, pod.Name, r.waitForLogsDuration)
-						mu.Unlock()
-						return
-					}
-					if !relevantLogMsg(t) {
-						continue
-					} else {
-						log.Debug(t)
-						log.Debugf("%s: relevant logs found :)\n", pod.Name)
-						return
-					}
+				mu.Lock()
+				log.Errorf(logNotFound, pod.Name, timeout)
+				e = err
+				mu.Unlock()
+				return
+			}
+			defer stream.Close()
 
-				}
+			scanner := r.newLogScanner(stream)
 
-				if err := scanner.Err(); err != nil {
-					log.Fatal(err)
+			log.Debugf("%s: looking for relevant logs in the coredns pod logs\n", pod.Name)
+			for scanner.Scan() {
+				t := scanner.Text()
+				if _, relevant, _ := r.logParser.Parse(t); relevant {
+					log.Debug(t)
+					log.Debugf("%s: relevant logs found :)\n", pod.Name)
+					return
 				}
+			}
 
-			}()
+			// the stream is closed when the timeout hits even if the pod doesn't log anything
+			if r.ctx.Err() != nil {
+				return
+			}
+			if podCtx.Err() != nil {
+				log.Infof("%s: giving up... :(\n", pod.Name)
+
+				mu.Lock()
+				log.Errorf(logNotFound, pod.Name, timeout)
+				e = fmt.Errorf(logNotFound, pod.Name, timeout)
+				mu.Unlock()
+				return
+			}
 
-		}
-		wg2.Wait()
-	}()
+			if err := scanner.Err(); err != nil {
+				mu.Lock()
+				e = err
+				mu.Unlock()
+			}
+		}()
+	}
 
 	wg.Wait()
 	return e
 }
 
+// podLogContext bounds the log stream of a single CoreDNS pod by timeoutPerPodLog
+// within the overall wait (parent). It returns the effective timeout of the pod
+func (r *Runner) podLogContext(parent context.Context) (context.Context, context.CancelFunc, time.Duration) {
+	if r.timeoutPerPodLog > 0 && r.timeoutPerPodLog < r.waitForLogsDuration {
+		ctx, cancel := context.WithTimeout(parent, r.timeoutPerPodLog)
+		return ctx, cancel, r.timeoutPerPodLog
+	}
+	ctx, cancel := context.WithCancel(parent)
+	return ctx, cancel, r.waitForLogsDuration
+}
+
+// firstPods returns a copy of the pod list with the first n pods by name
+// The pod list can be cached, so it isn't modified
+func firstPods(podList *v1.PodList, n int) *v1.PodList {
+	pods := append([]v1.Pod{}, podList.Items...)
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+
+	return &v1.PodList{TypeMeta: podList.TypeMeta, ListMeta: podList.ListMeta, Items: pods[:n]}
+}
+
+// findPodByIP finds the pod which has the IP
+// Pods using the host network share the IP of the node,
+// so they are only considered if no other pod has the IP
+func findPodByIP(clientset kubernetes.Interface, ip string) (*v1.Pod, error) {
+	podList, err := clientset.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("status.podIP=%s", ip),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []v1.Pod{}
+	for _, p := range podList.Items {
+		if !p.Spec.HostNetwork {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = podList.Items
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("IP %s doesn't belong to any current pod", ip)
+	case 1:
+		return &candidates[0], nil
+	default:
+		names := []string{}
+		for _, p := range candidates {
+			names = append(names, fmt.Sprintf("%s/%s", p.Namespace, p.Name))
+		}
+		return nil, fmt.Errorf("IP %s belongs to multiple pods: %s, please specify the pod name instead", ip, strings.Join(names, ", "))
+	}
+}
+
+// annotationFQDNs returns the comma separated FQDNs in the annotation of the pod
+// with the trailing dot e.g., `user-db.sock-shop.svc.cluster.local.`
+func annotationFQDNs(pod *v1.Pod, key string) []string {
+	fqdns := []string{}
+	for _, f := range strings.Split(pod.GetAnnotations()[key], ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		f = strings.TrimSuffix(f, ".") + "."
+		if !contains(fqdns, f) {
+			fqdns = append(fqdns, f)
+		}
+	}
+	return fqdns
+}
+
 // findToPodServiceFQDNs finds K8s Service associated with the toPod
 // and creates FQDNs out of them
 func (r *Runner) findToPodServiceFQDNs() ([]string, error) {
@@ -250,56 +1198,149 @@ func (r *Runner) findToPodServiceFQDNs() ([]string, error) {
 		return nil, err
 	}
 	for _, s := range sList.Items {
-		selector := s.Spec.Selector
-		for k, v := range selector {
-			if r.toPod.GetLabels()[k] != v {
-				break
-			} else {
+		if r.targetAllServices {
+			if r.endpointsHavePod(s) {
 				toPodServices = append(toPodServices, s)
 			}
+			continue
+		}
+		if serviceSelectsPod(s, r.toPod) {
+			toPodServices = append(toPodServices, s)
 		}
 	}
 
+	r.toPodServices = toPodServices
 	toPodServiceFQDNs := []string{}
 	for _, s := range toPodServices {
-		fqdn := fmt.Sprintf("%s.%s.svc.cluster.local.", s.Name, s.Namespace)
+		fqdn := fmt.Sprintf("%s.%s%s", s.Name, s.Namespace, r.logFilter.fqdnSuffix())
 		toPodServiceFQDNs = append(toPodServiceFQDNs, fqdn)
+
+		// a headless service resolves to the pod IPs and
+		// has a record per pod with a hostname and the service as the subdomain
+		// https://kubernetes.io/docs/concepts/services-networking/dns-pod-service/#pod-s-hostname-and-subdomain-fields
+		if headlessService(s) && r.toPod.Spec.Hostname != "" && r.toPod.Spec.Subdomain == s.Name {
+			r.toPodHostnameFQDN = fmt.Sprintf("%s.%s", r.toPod.Spec.Hostname, fqdn)
+			toPodServiceFQDNs = append(toPodServiceFQDNs, r.toPodHostnameFQDN)
+		}
 	}
 
 	return toPodServiceFQDNs, nil
 }
 
+// warnNoRelevantLogs explains why parseConnectionLogs might not find any relevant logs
+// even though waitForLogs found one
+func (r *Runner) warnNoRelevantLogs() {
+	r.warnf(WarningNoRelevantLogs, "found a relevant log while waiting but no relevant logs in the logs of %d CoreDNS pod(s)", len(r.coreDNSPods.Items))
+	log.Warn("possible causes:")
+	log.Warn("- the logs were rotated between waiting and reading them (try again or increase the log size limit of the kubelet)")
+	log.Warn("- the log retention is too short to have any queries for the pod's services (try `--watch`)")
+	log.Warnf("- the queries were answered by a CoreDNS replica which doesn't match `%s` in namespace `%s`", r.corednsSelector, r.corednsNamespace)
+}
+
 // parseConnectionLogs reads logs and parses them into
 // ConnectionLog struct
-func (r *Runner) parseConnectionLogs() ([]*ConnectionLog, error) {
+func (r *Runner) parseConnectionLogs(since *metav1.Time) ([]*ConnectionLog, error) {
+	if r.loki != nil {
+		return r.parseLokiConnectionLogs()
+	}
+	if len(r.logFiles) > 0 {
+		return r.parseFileConnectionLogs()
+	}
+
+	// the timestamps would prevent joining the continuation lines
+	timestamps := r.logFilter.ContinuationPrefix == ""
+
 	connLogList := []*ConnectionLog{}
 	ctx2 := context.Background()
 	for _, pod := range r.coreDNSPods.Items {
-		req := r.clientset.CoreV1().Pods("kube-system").GetLogs(pod.Name, &v1.PodLogOptions{})
+		// a poll reads the logs around the previous one again
+		// because SinceTime has a precision of seconds
+		_, read := r.lastLogTimes[pod.Name]
+		skipRead := since != nil && read
+		readAt := time.Now()
+		req := r.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{SinceTime: since, Timestamps: timestamps})
 		stream, err := req.Stream(ctx2)
 		if err != nil {
 			return nil, err
 		}
 		defer stream.Close()
 
-		scanner := bufio.NewScanner(stream)
-		// scanner has a limitation where it can read max 65536 characters
-		// More info and solution: https://stackoverflow.com/a/16615559/6874596
-		for scanner.Scan() {
-			t := scanner.Text()
-			c, err, success := parseLogMsg(t)
-			if err != nil {
-				return nil, err
-			}
+		if !skipRead || !timestamps {
+			r.lastLogTimes[pod.Name] = readAt
+		}
+		l, err := r.scanConnectionLogs(pod.Name, stream, timestamps, skipRead)
+		if err != nil {
+			return nil, err
+		}
+		connLogList = append(connLogList, l...)
+	}
+
+	return connLogList, nil
+}
+
+// scanConnectionLogs parses the logs of the CoreDNS pod
+// With skipRead, the lines read from the pod before are skipped i.e., the lines
+// logged at or before the last line read (see lastLogTimes) or, without
+// timestamps, the lines up to the last line read (see lastLogLines)
+func (r *Runner) scanConnectionLogs(pod string, rd io.Reader, timestamps, skipRead bool) ([]*ConnectionLog, error) {
+	connLogList := []*ConnectionLog{}
+	parse := func(logTime time.Time, t string) error {
+		r.linesScanned[pod]++
+		if r.rawLogs != nil {
+			r.rawLogs[pod] = append(r.rawLogs[pod], t)
+		}
+		r.countRcode(t)
+
+		c, success, err := r.logParser.Parse(t)
+		if err != nil {
+			return err
+		}
+
+		if success {
+			c.Time = logTime
+			connLogList = append(connLogList, c)
+		}
+		return nil
+	}
 
-			if success {
-				connLogList = append(connLogList, c)
+	last, lastLine := r.lastLogTimes[pod], r.lastLogLines[pod]
+	// without timestamps, the lines are parsed once it's known
+	// whether the last line read before shows up
+	pending := []string{}
+	scanner := r.newLogScanner(rd)
+	for scanner.Scan() {
+		logTime, t := time.Time{}, scanner.Text()
+		if timestamps {
+			logTime, t = splitLogTimestamp(t)
+			if skipRead && !logTime.After(last) {
+				// already read
+				continue
+			}
+			r.lastLogTimes[pod] = logTime
+		} else {
+			r.lastLogLines[pod] = t
+			if skipRead {
+				if t == lastLine {
+					// the pending lines were read before
+					pending = pending[:0]
+					continue
+				}
+				pending = append(pending, t)
+				continue
 			}
+		}
 
+		if err := parse(logTime, t); err != nil {
+			return nil, err
 		}
+	}
 
-		if err := scanner.Err(); err != nil {
-			log.Fatal(err)
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, t := range pending {
+		if err := parse(time.Time{}, t); err != nil {
+			return nil, err
 		}
 	}
 
@@ -308,7 +1349,7 @@ func (r *Runner) parseConnectionLogs() ([]*ConnectionLog, error) {
 
 // relevantLogMsg returns true if the log message is relevant for us i.e.,
 // it is the log message we want
-func relevantLogMsg(rawText string) bool {
+func relevantLogMsg(rawText string, f LogFilter) bool {
 	// Check for substring in the order in which they appear in the raw text
 	// because Go uses short-circuit evaluation of `&&`. That is,
 	// `don't go to the next && if the current one is not true`
@@ -317,29 +1358,37 @@ func relevantLogMsg(rawText string) bool {
 	// [INFO] 10.42.2.90:59003 - 9687 "AAAA IN user-db.sock-shop.svc.cluster.local. udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s
 	// It follows the default logging format of the CoreDNS `log` plugin
 	// More info: https://coredns.io/plugins/log/#log-format
-	return strings.HasPrefix(rawText, "[INFO]") &&
-		strings.Contains(rawText, fqdnSuffix) &&
+	return strings.HasPrefix(rawText, f.LogLevelMarker) &&
+		(f.clusterSuffixIndex(rawText) >= 0 || f.matchesExtraFQDN(rawText) || f.matchesPTR(rawText)) &&
+		(!f.StrictFQDN || f.strictFQDNMatch(rawText)) &&
 		// NOERROR indicates success
 		// https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#dns-parameters-6
-		strings.Contains(rawText, "NOERROR") &&
+		(!f.RequireNoError || strings.Contains(rawText, "NOERROR")) &&
 		// to match IP:PORT e.g., 10.42.2.90:59003
 		strings.Contains(rawText, ":")
 }
 
-func parseLogMsg(rawText string) (*ConnectionLog, error, bool) {
+func parseLogMsg(rawText string, f LogFilter) (*ConnectionLog, error, bool) {
 	var c *ConnectionLog
 
-	if !relevantLogMsg(rawText) {
+	if !relevantLogMsg(rawText, f) {
 		return c, nil, false
 	}
 
-	si := strings.Index(rawText, fqdnSuffix)
+	si := f.clusterSuffixIndex(rawText)
 
 	var fqdn string
+	if si < 0 {
+		// the log is relevant because it matches one of the extra FQDNs
+		// or it is a PTR query
+		fqdn, _, _ = queryRcode(rawText, f.LogLevelMarker)
+	}
 	// PoC: https://go.dev/play/p/xb3wDprPdOT
 	for i := si; i >= 0; i-- {
 		if rawText[i:i+1] == " " {
 			fqdn = rawText[i+1 : si]
+			// normalize FQDNs with or without the trailing dot to have the trailing dot
+			fqdn = fqdn + f.fqdnSuffix()
 			break
 		}
 	}
@@ -348,8 +1397,6 @@ func parseLogMsg(rawText string) (*ConnectionLog, error, bool) {
 		return c, fmt.Errorf("FQDN not found in the log '%v'", rawText), false
 	}
 
-	fqdn = fqdn + fqdnSuffix
-
 	eiText := strings.Split(rawText, " ")[1]
 	var ip string
 	var port string
@@ -362,6 +1409,9 @@ func parseLogMsg(rawText string) (*ConnectionLog, error, bool) {
 		}
 	}
 
+	// IPv6 addresses are logged in brackets e.g., [fd00::1]:59003
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+
 	if ip == "" {
 		return c, fmt.Errorf("pod ip not found in the log '%v'", rawText), false
 	}
@@ -369,15 +1419,44 @@ func parseLogMsg(rawText string) (*ConnectionLog, error, bool) {
 		return c, fmt.Errorf("pod port not found in the log '%v'", rawText), false
 	}
 
+	_, rcode, _ := queryRcode(rawText, f.LogLevelMarker)
+	queryID, transport, _ := queryIDTransport(rawText)
+
 	c = &ConnectionLog{
-		FromIP:     ip,
-		FromPort:   port,
-		ToHostname: fqdn,
+		Status:       rcode,
+		FromIP:       normalizeIP(ip),
+		RawFromIP:    ip,
+		FromPort:     port,
+		ToHostname:   fqdn,
+		QueryID:      queryID,
+		Transport:    transport,
+		ResponseSize: responseSize(rawText),
+	}
+	if f.matchesPTR(rawText) {
+		c.PTRIP = ptrIP(fqdn)
 	}
 
 	return c, nil, true
 }
 
+// normalizeIP strips the zone from a zone-scoped IPv6 address e.g., fe80::1%eth0
+// and converts IPv4-mapped IPv6 addresses e.g., ::ffff:10.42.2.90 to IPv4
+// so that the IP can be matched against endpoint addresses
+func normalizeIP(ip string) string {
+	if i := strings.Index(ip, "%"); i >= 0 {
+		ip = ip[:i]
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String()
+	}
+	return parsed.String()
+}
+
 // processConnectionLogs processes connection logs
 // and prints useful info around connection logs
 func (r *Runner) processConnectionLogs() error {
@@ -428,93 +1507,311 @@ func (r *Runner) processConnectionLogsSegment(connectionLogsSegment []*Connectio
 	return nil
 }
 
-// processConnectionLog processes a single connection log
-func (r *Runner) processConnectionLog(c *ConnectionLog) error {
-	var fromPodName string
-	var fromNs string
-	var found bool
-
-	for _, f := range r.toPodServiceFQDNs {
-
-		if c.ToHostname == f {
-
-			for _, n := range r.allNamespaces.Items {
-
-				for _, e := range r.allEndpoints[n.Name].Items {
-					for _, es := range e.Subsets {
-						for _, ea := range es.Addresses {
-							if ea.IP == c.FromIP && ea.TargetRef.Kind == "Pod" {
-								fromPodName = ea.TargetRef.Name
-								fromNs = ea.TargetRef.Namespace
-								found = true
-								break
-							}
-						}
-						if found {
-							break
-						}
+// indexPodsByIP maps the IPs in all the endpoints to the pods they belong to
+// so that a connection log can be resolved to a pod without going
+// through all the namespaces/endpoints/subsets/addresses every time
+// If an IP is found more than once, the first one wins
+func (r *Runner) indexPodsByIP() {
+	r.podsByIP = map[string]*Mapping{}
+	// allEndpoints can have namespaces which are not in allNamespaces
+	// e.g., a namespace created between listing the namespaces and the endpoints
+	namespaces := make([]string, 0, len(r.allEndpoints))
+	for ns := range r.allEndpoints {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		for _, e := range r.allEndpoints[ns].Items {
+			for _, es := range e.Subsets {
+				for _, ea := range es.Addresses {
+					// addresses without a TargetRef e.g., manually specified IPs
+					// can't be resolved to a pod
+					if ea.TargetRef == nil || ea.TargetRef.Kind != "Pod" {
+						continue
+					}
+					if _, ok := r.podsByIP[ea.IP]; ok {
+						continue
 					}
-					if found {
-						break
+					// TargetRef.Namespace is optional, the pod is in the namespace of the Endpoints then
+					namespace := ea.TargetRef.Namespace
+					if namespace == "" {
+						namespace = e.Namespace
 					}
-				}
-				if found {
-					break
+					r.podsByIP[ea.IP] = &Mapping{podname: ea.TargetRef.Name, namespace: namespace}
 				}
 			}
+		}
+	}
+}
 
-			if r.hostnamePodMapping[c.ToHostname] == nil {
-				r.hostnamePodMapping[c.ToHostname] = []*Mapping{}
-			}
+// refreshPodsByIP re-fetches the namespaces and the endpoints and re-indexes podsByIP
+// e.g., for a caller in a namespace created after the endpoints were listed
+// It refreshes at most once every podsByIPRefreshInterval so that
+// the queries from IPs outside the cluster don't re-fetch every time
+func (r *Runner) refreshPodsByIP() bool {
+	if time.Since(r.podsByIPRefreshed) < podsByIPRefreshInterval {
+		return false
+	}
+	r.podsByIPRefreshed = time.Now()
 
-			var present bool
-			for _, p := range r.hostnamePodMapping[c.ToHostname] {
-				if p.podname == fromPodName {
-					present = true
-					break
-				}
-			}
-			if !present {
+	r.cache.invalidateNamespacesEndpoints()
+	nsList, allEps, err := r.cache.namespacesEndpoints(r.clientset, r.concurrency)
+	if err != nil {
+		log.Errorf("couldn't re-fetch the endpoints: %v", err)
+		r.addWarning(WarningAPIError, "couldn't re-fetch the endpoints: %v", err)
+		return false
+	}
+	r.allNamespaces = nsList
+	r.allEndpoints = allEps
+	r.indexPodsByIP()
+	return true
+}
 
-				r.hostnamePodMapping[c.ToHostname] = append(r.hostnamePodMapping[c.ToHostname], &Mapping{podname: fromPodName, namespace: fromNs})
+// processConnectionLog processes a single connection log
+func (r *Runner) processConnectionLog(c *ConnectionLog) error {
+	if !r.targetsToPod(c) {
+		return nil
+	}
 
-				log.Infof("pod: %s, ns: %s via svc: %s\n", fromPodName, fromNs, c.ToHostname)
-			}
+	if r.largeResponse && c.ResponseSize > largeResponseSize {
+		r.addLargeResponse(c)
+	}
 
-			break
+	m, ok := r.podsByIP[c.FromIP]
+	_, isNode := r.nodesByIP[c.FromIP]
+	if !ok && !isNode && r.refreshPodsByIP() {
+		// the caller might be new since the endpoints were listed
+		m, ok = r.podsByIP[c.FromIP]
+	}
+	if !ok || isNode {
+		// a host network pod shares the node IP, so
+		// matching it to a pod could misattribute the connection
+		r.addUnresolved(c)
+		return nil
+	}
+	fromPodName := m.podname
+	fromNs := m.namespace
+
+	if contains(r.excludedNamespaces, fromNs) || r.excludedCaller(fromPodName, fromNs) {
+		return nil
+	}
 
+	if r.hostnamePodMapping[c.ToHostname] == nil {
+		r.hostnamePodMapping[c.ToHostname] = []*Mapping{}
+	}
+
+	for _, p := range r.hostnamePodMapping[c.ToHostname] {
+		// pods in different namespaces can have the same name
+		if p.podname == fromPodName && p.namespace == fromNs {
+			p.lastSeen = time.Now()
+			p.count++
+			p.addSample(c.Line)
+			p.addTransport(c.Transport)
+			p.addQueryTime(c.Time)
+			return nil
+		}
+	}
+
+	m = &Mapping{podname: fromPodName, namespace: fromNs, queryID: c.QueryID, transport: c.Transport, lastSeen: time.Now(), count: 1}
+	m.addSample(c.Line)
+	m.addTransport(c.Transport)
+	m.addQueryTime(c.Time)
+	r.hostnamePodMapping[c.ToHostname] = append(r.hostnamePodMapping[c.ToHostname], m)
+
+	if r.onConnection != nil {
+		r.onConnection(c, m)
+	}
+
+	if r.stopAtFirstConnection && r.stopWatching != nil {
+		r.stopWatching()
+	}
+
+	r.sink.send(ConnectionEvent{
+		Context:         r.kubeContext,
+		TargetPod:       r.anonymizer.Pod(r.toPod.Name),
+		TargetNamespace: r.anonymizer.Namespace(r.toPod.Namespace),
+		Connection: r.anonymizer.connection(Connection{
+			FromPod:       m.podname,
+			FromNamespace: m.namespace,
+			ToFQDN:        c.ToHostname,
+			QueryID:       m.queryID,
+			Transport:     m.transport,
+			Relation:      r.relation(m.podname, m.namespace),
+		}),
+	})
+
+	if r.printEachConnection() {
+		pod := r.colorize(colorPod, r.anonymizer.Pod(fromPodName))
+		ns := r.colorize(colorNamespace, r.anonymizer.Namespace(fromNs))
+		svc := r.colorize(colorService, r.anonymizer.fqdn(c.ToHostname))
+		relation := relationSuffix(r.relation(fromPodName, fromNs))
+		if r.output == OutputWide {
+			fmt.Printf("pod: %s, ns: %s via svc: %s%s (query id: %s, transport: %s, last seen: %s)\n", pod, ns, svc, relation, c.QueryID, c.Transport, lastSeenAge(m.LastQueried()))
+		} else {
+			fmt.Printf("pod: %s, ns: %s via svc: %s%s\n", pod, ns, svc, relation)
 		}
 	}
 
 	return nil
 }
 
-// suggestNetPol suggests a NetworkPolicy K8s resource
-func (r *Runner) suggestNetPol() error {
+// excludedCaller returns true if the caller pod is one of excludedPods
+// or is selected by excludedPodSelector
+func (r *Runner) excludedCaller(podname, namespace string) bool {
+	if contains(r.excludedPods, podname) || contains(r.excludedPods, namespace+"/"+podname) {
+		return true
+	}
+	if r.excludedPodSelector == nil {
+		return false
+	}
 
-	netPolPeers := []networkingv1.NetworkPolicyPeer{}
+	key := namespace + "/" + podname
+	if excluded, ok := r.excludedCallers[key]; ok {
+		return excluded
+	}
+
+	pod, err := r.clientset.CoreV1().Pods(namespace).Get(context.Background(), podname, metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("couldn't get pod %s to match the excluded pod selector: %v", key, err)
+		r.addWarning(WarningAPIError, "couldn't get pod %s to match the excluded pod selector: %v", key, err)
+		return false
+	}
 
-	fmt.Println("")
-	fmt.Println("creating a NetworkPolicy suggestion...")
+	r.excludedCallers[key] = r.excludedPodSelector.Matches(labels.Set(pod.GetLabels()))
+	return r.excludedCallers[key]
+}
+
+// serviceSelectsPod returns true if all the keys in the selector of the service
+// match the labels of the pod e.g., `app=user-db,tier=db` selects a pod with
+// `app: user-db`, `tier: db` and `pod-template-hash: b8dfb847c`
+// but not a pod with only `app: user-db`
+// A service without a selector doesn't select any pods
+func serviceSelectsPod(s v1.Service, pod *v1.Pod) bool {
+	if len(s.Spec.Selector) == 0 {
+		return false
+	}
+	return labels.SelectorFromSet(s.Spec.Selector).Matches(labels.Set(pod.GetLabels()))
+}
+
+// endpointsHavePod returns true if the Endpoints of the service have one of the toPod IPs
+// Unlike serviceSelectsPod, it works for services without a selector
+// (with manually managed Endpoints) and unusual selectors too
+func (r *Runner) endpointsHavePod(s v1.Service) bool {
+	eps, ok := r.allEndpoints[s.Namespace]
+	if !ok {
+		return false
+	}
+
+	podIPs := map[string]bool{normalizeIP(r.toPod.Status.PodIP): true}
+	for _, ip := range r.toPod.Status.PodIPs {
+		podIPs[normalizeIP(ip.IP)] = true
+	}
+	delete(podIPs, "")
+
+	for _, e := range eps.Items {
+		if e.Name != s.Name {
+			continue
+		}
+		for _, subset := range e.Subsets {
+			for _, a := range append(subset.Addresses, subset.NotReadyAddresses...) {
+				if podIPs[normalizeIP(a.IP)] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// printMultipleServices prints a note if more than one service selects the toPod
+// because it is either intentional or accidental dual exposure of the pod
+// which needs to be considered while designing the NetworkPolicy
+func (r *Runner) printMultipleServices() {
+	r.printHeadlessServices()
+
+	fqdns := []string{}
+	for _, f := range r.toPodServiceFQDNs {
+		if f == r.toPodHostnameFQDN {
+			continue
+		}
+		fqdns = append(fqdns, r.anonymizer.fqdn(f))
+	}
+	if len(fqdns) < 2 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "note: pod %s is selected by %d services: %s\n", r.anonymizer.Pod(r.toPod.Name), len(fqdns), strings.Join(fqdns, ", "))
+	fmt.Fprintln(os.Stderr, "note: callers are listed under the service they queried")
+}
+
+// targetsToPod returns true if the connection log is a query for the toPod
+// i.e., for one of its service FQDNs, one of the extra FQDNs
+// or a reverse (PTR) lookup of its IP (or for any of the workloadPods)
+func (r *Runner) targetsToPod(c *ConnectionLog) bool {
+	if contains(r.toPodServiceFQDNs, c.ToHostname) || contains(r.workloadPodFQDNs, c.ToHostname) || matchFQDN(r.logFilter.ExtraFQDNs, c.ToHostname) {
+		return true
+	}
+
+	if c.PTRIP == "" {
+		return false
+	}
+	if r.workloadPodIPs[c.PTRIP] {
+		return true
+	}
+	for _, ip := range r.toPod.Status.PodIPs {
+		if normalizeIP(ip.IP) == c.PTRIP {
+			return true
+		}
+	}
+	return normalizeIP(r.toPod.Status.PodIP) == c.PTRIP
+}
+
+// buildNetPol builds a NetworkPolicy K8s resource
+// which allows incoming connections from the pods in hostnamePodMapping
+func (r *Runner) buildNetPol() (*networkingv1.NetworkPolicy, []*peerSource, error) {
+	return r.buildNetPolFrom(nil)
+}
+
+// buildNetPolFrom builds the NetworkPolicy allowing only the callers owned by the workload
+// (all the callers if the workload is nil)
+func (r *Runner) buildNetPolFrom(from *Workload) (*networkingv1.NetworkPolicy, []*peerSource, error) {
+	netPolPeers := []networkingv1.NetworkPolicyPeer{}
+	// sources[i] is the rationale behind netPolPeers[i]
+	sources := []*peerSource{}
 
 	// TODO: this code has a lot of loops and duplicate get pod api calls
-	for _, mappings := range r.hostnamePodMapping {
+	for hostname, mappings := range r.hostnamePodMapping {
 		for _, mapping := range mappings {
+			if from != nil {
+				owner, err := r.podOwner(mapping.podname, mapping.namespace)
+				if err != nil {
+					return nil, nil, err
+				}
+				if *owner != *from {
+					continue
+				}
+			}
+
 			fromPod, err := r.clientset.CoreV1().Pods(mapping.namespace).Get(context.Background(), mapping.podname, metav1.GetOptions{})
 			if err != nil {
-				log.Errorf("couldn't get pod: %w", err)
+				log.Errorf("couldn't get pod: %v", err)
+				r.addWarning(WarningAPIError, "couldn't get pod: %v", err)
 			}
 
-			l := fromPod.GetLabels()
+			l := r.withoutIgnoredLabels(fromPod.GetLabels())
 
-			for _, ignoredLabel := range ignoredPodLabels {
-				delete(l, ignoredLabel)
+			if r.minimalPeerLabels && len(l) > 1 {
+				l, err = r.minimalLabels(fromPod, l)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 
 			var found bool
-			for _, netPolPeer := range netPolPeers {
+			for i, netPolPeer := range netPolPeers {
 				if reflect.DeepEqual(netPolPeer.PodSelector.MatchLabels, l) {
 					found = true
+					sources[i].add(r.anonymizer.Namespace(mapping.namespace)+"/"+r.anonymizer.Pod(mapping.podname), r.anonymizer.fqdn(hostname), mapping)
 				}
 			}
 
@@ -524,24 +1821,41 @@ func (r *Runner) suggestNetPol() error {
 						MatchLabels: l,
 					},
 				})
+				src := &peerSource{}
+				src.add(r.anonymizer.Namespace(mapping.namespace)+"/"+r.anonymizer.Pod(mapping.podname), r.anonymizer.fqdn(hostname), mapping)
+				sources = append(sources, src)
 			}
 
 		}
 
 	}
 
-	toPodLabels := r.toPod.GetLabels()
-	for _, ignoredLabel := range ignoredPodLabels {
-		delete(toPodLabels, ignoredLabel)
+	if r.mergeSubsetPeers {
+		netPolPeers, sources = mergeSubsetPeers(netPolPeers, sources)
+	}
+
+	// hostnamePodMapping is a map, so sort the peers to keep the output stable across runs
+	// (label keys within a peer are sorted by the yaml encoder)
+	sort.Sort(&peersBySelector{peers: netPolPeers, sources: sources})
+	netPolPeers, sources = r.withoutExcludedPeers(netPolPeers, sources)
+
+	toPodLabels := r.withoutIgnoredLabels(r.toPod.GetLabels())
+	if len(toPodLabels) == 0 {
+		r.warnf(WarningNetworkPolicy, "pod %s has no labels other than the ignored ones, the suggested NetworkPolicy selects all the pods in the namespace", r.anonymizer.Pod(r.toPod.Name))
+	}
+
+	name := fmt.Sprintf("%s-ingress", r.toPod.Name)
+	if from != nil {
+		name = fmt.Sprintf("%s-from-%s-%s", name, strings.ToLower(from.Kind), from.Name)
 	}
 
-	n := networkingv1.NetworkPolicy{
+	n := &networkingv1.NetworkPolicy{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "NetworkPolicy",
-			APIVersion: "networking.k8s.io/v1",
+			APIVersion: r.policyAPIVersion,
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("%s-ingress", r.toPod.Name),
+			Name: name,
 		},
 		Spec: networkingv1.NetworkPolicySpec{
 			PodSelector: metav1.LabelSelector{
@@ -549,35 +1863,268 @@ func (r *Runner) suggestNetPol() error {
 			},
 			Ingress: []networkingv1.NetworkPolicyIngressRule{
 				{
-					From: netPolPeers,
+					From:  netPolPeers,
+					Ports: r.toPodPolicyPorts(),
 				},
 			},
 		},
 	}
 
-	y, err := json.Marshal(n)
+	// the ipBlock peers go in their own rule, the unresolved callers don't belong to a workload
+	if r.includeIPBlocks && from == nil {
+		if ipBlocks := r.ipBlockPeers(); len(ipBlocks) > 0 {
+			rule := networkingv1.NetworkPolicyIngressRule{From: ipBlocks, Ports: r.toPodPolicyPorts()}
+			if len(netPolPeers) == 0 {
+				// an ingress rule without peers allows everything
+				n.Spec.Ingress = nil
+			}
+			n.Spec.Ingress = append(n.Spec.Ingress, rule)
+		}
+	}
+
+	return r.anonymizer.networkPolicy(n), sources, nil
+}
+
+// suggestNetPol suggests a NetworkPolicy K8s resource
+func (r *Runner) suggestNetPol() error {
+	nps, err := r.existingNetPols()
+	if err != nil {
+		return err
+	}
+	for i := range nps {
+		nps[i] = *r.anonymizer.networkPolicy(&nps[i])
+	}
+	printExistingNetPols(r.anonymizer.Pod(r.toPod.Name), nps)
+
+	if r.policyList != nil {
+		// printed later along with the NetworkPolicies of other pods
+		return nil
+	}
+
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "creating a NetworkPolicy suggestion...")
+
+	tooMany, err := r.tooManyPeers()
+	if err != nil {
+		return err
+	}
+	if tooMany {
+		return nil
+	}
+
+	b, err := r.netPolYAML()
+	if err != nil {
+		return err
+	}
+
+	printBanner("SUGGESTED NetworkPolicy")
+	fmt.Printf("%s", string(b))
+
+	if r.smartDNSEgress {
+		return r.suggestDNSEgressNetPols()
+	}
+	return nil
+}
+
+// suggestDNSEgressNetPols prints the suggested DNS egress NetworkPolicies
+// of the calling workloads (if any)
+func (r *Runner) suggestDNSEgressNetPols() error {
+	nps, err := r.dnsEgressNetPols()
+	if err != nil {
+		return err
+	}
+
+	printBanner("SUGGESTED DNS egress NetworkPolicies")
+	if len(nps) == 0 {
+		fmt.Fprintln(os.Stderr, "none: the calling workloads already allow DNS egress to CoreDNS")
+		return nil
+	}
+
+	for i, n := range nps {
+		b, err := policyYAML(n)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Printf("%s", string(b))
+	}
+	return nil
+}
+
+// printAnyConnection prints `yes` if there is an incoming connection, otherwise `no`
+// With watch, it waits for the first connection if there is none yet
+func (r *Runner) printAnyConnection() error {
+	if len(r.hostnamePodMapping) == 0 && r.watch {
+		if err := r.watchConnectionLogs(r.ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(r.hostnamePodMapping) > 0 {
+		fmt.Println("yes")
+		return nil
+	}
+	fmt.Println("no")
+	return nil
+}
+
+// printPolicyOnly prints only the suggested NetworkPolicies (if any)
+// Every document starts with `---` so that the output of multiple pods
+// can be piped to `kubectl apply -f -`
+func (r *Runner) printPolicyOnly() error {
+	tooMany, err := r.tooManyPeers()
+	if err != nil {
+		return err
+	}
+	if tooMany {
+		return nil
+	}
+
+	b, err := r.netPolYAML()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("---\n%s", string(b))
+
+	if !r.smartDNSEgress {
+		return nil
+	}
+
+	nps, err := r.dnsEgressNetPols()
 	if err != nil {
 		return err
 	}
+	for _, n := range nps {
+		b, err := policyYAML(n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("---\n%s", string(b))
+	}
+	return nil
+}
+
+// tooManyPeers returns true (and warns) if the suggested NetworkPolicy
+// has more than maxPeers peers. Such a policy usually means the callers
+// need to be narrowed down rather than allowed one by one
+func (r *Runner) tooManyPeers() (bool, error) {
+	if r.maxPeers <= 0 {
+		return false, nil
+	}
+
+	n, sources, err := r.buildNetPol()
+	if err != nil {
+		return false, err
+	}
+	peers := 0
+	if len(n.Spec.Ingress) > 0 {
+		peers = len(n.Spec.Ingress[0].From)
+	}
+	if peers <= r.maxPeers {
+		return false, nil
+	}
+
+	r.warnf(WarningNetworkPolicy, "not suggesting a NetworkPolicy for pod %s: it has %d peers (--max-peers is %d)", r.anonymizer.Pod(r.toPod.Name), peers, r.maxPeers)
+	log.Warnf("namespaces with the most calling pods: %s", strings.Join(topNamespaces(sources, 3), ", "))
+	log.Warnf("refine the callers e.g., with --exclude-namespaces, --exclude-pod-selector or --target-fqdn to pick a service")
+	return true, nil
+}
+
+// policyYAML renders a NetworkPolicy as YAML
+func policyYAML(n *networkingv1.NetworkPolicy) ([]byte, error) {
+	y, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	v := map[string]interface{}{}
+	if err := json.Unmarshal(y, &v); err != nil {
+		return nil, err
+	}
+	return encodeYAML(&v)
+}
+
+// netPolYAML renders the suggested NetworkPolicies as YAML
+// separated by `---` (see PolicyGranularityWorkload)
+func (r *Runner) netPolYAML() ([]byte, error) {
+	nps, sources, err := r.suggestedNetPols()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{}
+	for i, n := range nps {
+		b, err := r.explainedPolicyYAML(n, sources[i])
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			out = append(out, "---\n"...)
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// explainedPolicyYAML renders a NetworkPolicy as YAML
+// annotating the peers with their sources if explain is set
+func (r *Runner) explainedPolicyYAML(n *networkingv1.NetworkPolicy, sources []*peerSource) ([]byte, error) {
+	y, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
 
 	v := map[string]interface{}{}
 	err = json.Unmarshal(y, &v)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var doc interface{} = &v
+	if r.explain || r.includeIPBlocks {
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return nil, err
+		}
+		if r.explain {
+			annotatePeers(node, sources)
+		}
+		annotateIPBlockRule(node)
+		doc = node
 	}
 
+	return encodeYAML(doc)
+}
+
+// TextOutput returns true if the output format is meant for humans
+func TextOutput(output string) bool {
+	return output == OutputText || output == OutputWide || output == OutputTable
+}
+
+// printEachConnection returns true if every connection is printed as soon as it is found
+func (r *Runner) printEachConnection() bool {
+	return (r.output == OutputText || r.output == OutputWide) && !r.silent
+}
+
+// printBanner prints a section title to stderr
+// so that only the data goes to stdout
+func printBanner(title string) {
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, title)
+	fmt.Fprintln(os.Stderr, strings.Repeat("-", len(title)))
+}
+
+// encodeYAML encodes the document as YAML
+func encodeYAML(doc interface{}) ([]byte, error) {
 	// for spacing of 2 chars
 	var b bytes.Buffer
 	yamlEncoder := yaml.NewEncoder(&b)
 	yamlEncoder.SetIndent(2)
-	err = yamlEncoder.Encode(&v)
+	err := yamlEncoder.Encode(doc)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println("")
-	fmt.Println("SUGGESTED NetworkPolicy")
-	fmt.Println("-----------------------")
-	fmt.Printf("%s", string(b.String()))
-	return nil
+	return b.Bytes(), nil
 }
@@ -0,0 +1,545 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// podAddress is an endpoint address of the pod
+func podAddress(ip, pod, namespace string) v1.EndpointAddress {
+	return v1.EndpointAddress{IP: ip, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: pod, Namespace: namespace}}
+}
+
+// endpoints is an Endpoints object with a single subset of the addresses
+func endpoints(namespace, name string, addresses ...v1.EndpointAddress) v1.Endpoints {
+	return v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Subsets:    []v1.EndpointSubset{{Addresses: addresses}},
+	}
+}
+
+// indexedRunner returns a Runner with the endpoints indexed by indexPodsByIP
+// The namespaces are listed in the order given like the API server lists them
+func indexedRunner(namespaces []string, eps ...v1.Endpoints) *Runner {
+	r := &Runner{
+		allNamespaces: &v1.NamespaceList{},
+		allEndpoints:  map[string]*v1.EndpointsList{},
+	}
+	for _, ns := range namespaces {
+		r.allNamespaces.Items = append(r.allNamespaces.Items, v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+		r.allEndpoints[ns] = &v1.EndpointsList{}
+	}
+	for _, e := range eps {
+		if r.allEndpoints[e.Namespace] == nil {
+			r.allEndpoints[e.Namespace] = &v1.EndpointsList{}
+		}
+		r.allEndpoints[e.Namespace].Items = append(r.allEndpoints[e.Namespace].Items, e)
+	}
+	r.indexPodsByIP()
+	return r
+}
+
+// connectionRunner returns a Runner for the toPod `user-db-0` in `sock-shop`
+// processing the connection logs to the FQDNs without calling the API server
+func connectionRunner(fqdns []string, namespaces []string, eps ...v1.Endpoints) *Runner {
+	r := indexedRunner(namespaces, eps...)
+	r.toPod = &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "user-db-0", Namespace: "sock-shop"}}
+	r.toPodServiceFQDNs = fqdns
+	r.hostnamePodMapping = map[string][]*Mapping{}
+	r.logFilter = DefaultLogFilter
+	// the endpoints aren't re-fetched for the unknown IPs
+	r.podsByIPRefreshed = time.Now()
+	return r
+}
+
+// queryLog is a CoreDNS log of an `A` query from the IP for the FQDN
+func queryLog(ip, fqdn string) string {
+	return fmt.Sprintf(`[INFO] %s:59003 - 9687 "A IN %s udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s`, ip, fqdn)
+}
+
+// linearPodByIP is the lookup indexPodsByIP replaced: it goes through
+// all the namespaces/endpoints/subsets/addresses for every connection log
+// and stops at the first address with the IP
+func linearPodByIP(r *Runner, ip string) (Mapping, bool) {
+	for _, n := range r.allNamespaces.Items {
+		for _, e := range r.allEndpoints[n.Name].Items {
+			for _, es := range e.Subsets {
+				for _, ea := range es.Addresses {
+					if ea.TargetRef == nil || ea.TargetRef.Kind != "Pod" || ea.IP != ip {
+						continue
+					}
+					namespace := ea.TargetRef.Namespace
+					if namespace == "" {
+						namespace = e.Namespace
+					}
+					return Mapping{podname: ea.TargetRef.Name, namespace: namespace}, true
+				}
+			}
+		}
+	}
+	return Mapping{}, false
+}
+
+func TestIndexPodsByIPMatchesLinearLookup(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		endpoints  []v1.Endpoints
+	}{
+		{
+			name:       "one pod per IP",
+			namespaces: []string{"default", "sock-shop"},
+			endpoints: []v1.Endpoints{
+				endpoints("sock-shop", "user-db", podAddress("10.42.0.5", "user-db-0", "sock-shop")),
+				endpoints("sock-shop", "user", podAddress("10.42.0.6", "user-1", "sock-shop"), podAddress("10.42.1.6", "user-2", "sock-shop")),
+			},
+		},
+		{
+			name:       "a pod behind multiple services",
+			namespaces: []string{"sock-shop"},
+			endpoints: []v1.Endpoints{
+				endpoints("sock-shop", "user-db", podAddress("10.42.0.5", "user-db-0", "sock-shop")),
+				endpoints("sock-shop", "user-db-headless", podAddress("10.42.0.5", "user-db-0", "sock-shop")),
+			},
+		},
+		{
+			// e.g., stale endpoints of a deleted pod whose IP was reused
+			// The API server lists the namespaces sorted by name, so the first
+			// namespace of the linear lookup is the first sorted namespace of the index
+			name:       "an IP in multiple namespaces",
+			namespaces: []string{"a-team", "b-team", "c-team"},
+			endpoints: []v1.Endpoints{
+				endpoints("c-team", "api", podAddress("10.42.0.9", "api-0", "c-team")),
+				endpoints("a-team", "api", podAddress("10.42.0.9", "api-1", "a-team")),
+				endpoints("b-team", "api", podAddress("10.42.0.9", "api-2", "b-team")),
+			},
+		},
+		{
+			name:       "an IP twice in a namespace",
+			namespaces: []string{"sock-shop"},
+			endpoints: []v1.Endpoints{
+				endpoints("sock-shop", "carts", podAddress("10.42.0.7", "carts-old", "sock-shop")),
+				endpoints("sock-shop", "carts-db", podAddress("10.42.0.7", "carts-new", "sock-shop")),
+			},
+		},
+		{
+			name:       "addresses which aren't pods",
+			namespaces: []string{"default", "sock-shop"},
+			endpoints: []v1.Endpoints{
+				endpoints("default", "kubernetes", v1.EndpointAddress{IP: "172.18.0.2"}),
+				endpoints("default", "external", v1.EndpointAddress{IP: "10.42.0.8", TargetRef: &v1.ObjectReference{Kind: "Node", Name: "node-1"}}),
+				endpoints("sock-shop", "user-db", podAddress("10.42.0.8", "user-db-0", "sock-shop")),
+			},
+		},
+		{
+			name:       "a TargetRef without a namespace",
+			namespaces: []string{"sock-shop"},
+			endpoints: []v1.Endpoints{
+				endpoints("sock-shop", "user-db", podAddress("10.42.0.5", "user-db-0", "")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := indexedRunner(tt.namespaces, tt.endpoints...)
+
+			ips := map[string]bool{}
+			for _, e := range tt.endpoints {
+				for _, ea := range e.Subsets[0].Addresses {
+					ips[ea.IP] = true
+				}
+			}
+			for ip := range ips {
+				want, wantOK := linearPodByIP(r, ip)
+				got, gotOK := r.podsByIP[ip]
+				if gotOK != wantOK {
+					t.Errorf("%s: indexed = %v, linear lookup found = %v", ip, gotOK, wantOK)
+					continue
+				}
+				if gotOK && (got.podname != want.podname || got.namespace != want.namespace) {
+					t.Errorf("%s: indexed %s/%s, linear lookup %s/%s", ip, got.namespace, got.podname, want.namespace, want.podname)
+				}
+			}
+			if len(r.podsByIP) > len(ips) {
+				t.Errorf("indexed %d IPs, only %d in the endpoints", len(r.podsByIP), len(ips))
+			}
+		})
+	}
+}
+
+func BenchmarkIndexPodsByIP(b *testing.B) {
+	// 200 namespaces with 20 services of 5 pods each
+	namespaces := []string{}
+	eps := []v1.Endpoints{}
+	for n := 0; n < 200; n++ {
+		ns := fmt.Sprintf("ns-%03d", n)
+		namespaces = append(namespaces, ns)
+		for s := 0; s < 20; s++ {
+			addresses := []v1.EndpointAddress{}
+			for p := 0; p < 5; p++ {
+				addresses = append(addresses, podAddress(fmt.Sprintf("10.%d.%d.%d", n/250, n%250, s*5+p), fmt.Sprintf("svc-%d-%d", s, p), ns))
+			}
+			eps = append(eps, endpoints(ns, fmt.Sprintf("svc-%d", s), addresses...))
+		}
+	}
+	r := indexedRunner(namespaces, eps...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.indexPodsByIP()
+	}
+}
+
+func TestProcessConnectionLogWithoutTargetRef(t *testing.T) {
+	const fqdn = "user-db.sock-shop.svc.cluster.local."
+	tests := []struct {
+		name           string
+		address        v1.EndpointAddress
+		wantPod        string
+		wantUnresolved bool
+	}{
+		{
+			name:    "pod",
+			address: podAddress("10.42.0.8", "front-end-0", "sock-shop"),
+			wantPod: "front-end-0",
+		},
+		{
+			// e.g., an IP specified manually in an Endpoints without a selector
+			name:           "no TargetRef",
+			address:        v1.EndpointAddress{IP: "10.42.0.8"},
+			wantUnresolved: true,
+		},
+		{
+			name:           "TargetRef which isn't a pod",
+			address:        v1.EndpointAddress{IP: "10.42.0.8", TargetRef: &v1.ObjectReference{Kind: "Node", Name: "node-1"}},
+			wantUnresolved: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := connectionRunner([]string{fqdn}, []string{"sock-shop"}, endpoints("sock-shop", "front-end", tt.address))
+
+			c, err, ok := parseLogMsg(queryLog("10.42.0.8", fqdn), DefaultLogFilter)
+			if err != nil || !ok {
+				t.Fatalf("parseLogMsg() = %v, %v", err, ok)
+			}
+			if err := r.processConnectionLog(c); err != nil {
+				t.Fatalf("processConnectionLog() error = %v", err)
+			}
+
+			if got := len(r.unresolved) > 0; got != tt.wantUnresolved {
+				t.Errorf("unresolved = %v, want %v", got, tt.wantUnresolved)
+			}
+			mappings := r.hostnamePodMapping[fqdn]
+			if tt.wantPod == "" {
+				if len(mappings) != 0 {
+					t.Errorf("got %d callers, want none", len(mappings))
+				}
+				return
+			}
+			if len(mappings) != 1 || mappings[0].podname != tt.wantPod {
+				t.Errorf("got callers %v, want %s", mappings, tt.wantPod)
+			}
+		})
+	}
+}
+
+func TestParseLogMsgCallerIP(t *testing.T) {
+	const fqdn = "user-db.sock-shop.svc.cluster.local."
+	tests := []struct {
+		name      string
+		remote    string
+		wantIP    string
+		wantRawIP string
+		wantPort  string
+	}{
+		{name: "IPv4", remote: "10.42.2.90:59003", wantIP: "10.42.2.90", wantRawIP: "10.42.2.90", wantPort: "59003"},
+		{name: "IPv6", remote: "[fd00::1]:59003", wantIP: "fd00::1", wantRawIP: "fd00::1", wantPort: "59003"},
+		{name: "IPv6 not in the canonical form", remote: "[fd00:0:0::1]:59003", wantIP: "fd00::1", wantRawIP: "fd00:0:0::1", wantPort: "59003"},
+		{name: "zone-scoped IPv6", remote: "[fe80::1%eth0]:59003", wantIP: "fe80::1", wantRawIP: "fe80::1%eth0", wantPort: "59003"},
+		{name: "IPv4-mapped IPv6", remote: "[::ffff:10.42.2.90]:59003", wantIP: "10.42.2.90", wantRawIP: "::ffff:10.42.2.90", wantPort: "59003"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := fmt.Sprintf(`[INFO] %s - 9687 "A IN %s udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s`, tt.remote, fqdn)
+			c, err, ok := parseLogMsg(line, DefaultLogFilter)
+			if err != nil || !ok {
+				t.Fatalf("parseLogMsg() = %v, %v", err, ok)
+			}
+			if c.FromIP != tt.wantIP {
+				t.Errorf("FromIP = %q, want %q", c.FromIP, tt.wantIP)
+			}
+			if c.RawFromIP != tt.wantRawIP {
+				t.Errorf("RawFromIP = %q, want %q", c.RawFromIP, tt.wantRawIP)
+			}
+			if c.FromPort != tt.wantPort {
+				t.Errorf("FromPort = %q, want %q", c.FromPort, tt.wantPort)
+			}
+
+			// the normalized IP resolves to the pod with the IP in the endpoints
+			r := connectionRunner([]string{fqdn}, []string{"sock-shop"}, endpoints("sock-shop", "front-end", podAddress(tt.wantIP, "front-end-0", "sock-shop")))
+			if err := r.processConnectionLog(c); err != nil {
+				t.Fatalf("processConnectionLog() error = %v", err)
+			}
+			if mappings := r.hostnamePodMapping[fqdn]; len(mappings) != 1 || mappings[0].podname != "front-end-0" {
+				t.Errorf("%s isn't resolved to front-end-0 (unresolved: %v)", tt.remote, r.unresolved)
+			}
+		})
+	}
+}
+
+func TestParseLogMsgTrailingDot(t *testing.T) {
+	custom := LogFilter{LogLevelMarker: DefaultLogFilter.LogLevelMarker, ClusterDomain: "cluster.example"}
+	extra := LogFilter{LogLevelMarker: DefaultLogFilter.LogLevelMarker, ExtraFQDNs: []string{"user-db.example.com."}}
+	tests := []struct {
+		name   string
+		filter LogFilter
+		qname  string
+		// wantFQDN is empty if the log isn't relevant
+		wantFQDN string
+	}{
+		{name: "dotted", filter: DefaultLogFilter, qname: "user-db.sock-shop.svc.cluster.local.", wantFQDN: "user-db.sock-shop.svc.cluster.local."},
+		{name: "undotted", filter: DefaultLogFilter, qname: "user-db.sock-shop.svc.cluster.local", wantFQDN: "user-db.sock-shop.svc.cluster.local."},
+		{name: "dotted with a custom cluster domain", filter: custom, qname: "user-db.sock-shop.svc.cluster.example.", wantFQDN: "user-db.sock-shop.svc.cluster.example."},
+		{name: "undotted with a custom cluster domain", filter: custom, qname: "user-db.sock-shop.svc.cluster.example", wantFQDN: "user-db.sock-shop.svc.cluster.example."},
+		{name: "dotted outside the cluster domain", filter: extra, qname: "user-db.example.com.", wantFQDN: "user-db.example.com."},
+		{name: "undotted outside the cluster domain", filter: extra, qname: "user-db.example.com", wantFQDN: "user-db.example.com."},
+		{name: "longer last label", filter: DefaultLogFilter, qname: "user-db.sock-shop.svc.cluster.localhost."},
+		{name: "longer last label undotted", filter: DefaultLogFilter, qname: "user-db.sock-shop.svc.cluster.localhost"},
+		{name: "hyphenated last label", filter: DefaultLogFilter, qname: "user-db.sock-shop.svc.cluster.local-foo."},
+		{name: "longer last label with a custom cluster domain", filter: custom, qname: "user-db.sock-shop.svc.cluster.examples."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err, ok := parseLogMsg(queryLog("10.42.2.90", tt.qname), tt.filter)
+			if err != nil {
+				t.Fatalf("parseLogMsg() error = %v", err)
+			}
+			if ok != (tt.wantFQDN != "") {
+				t.Fatalf("parseLogMsg() relevant = %v, want %v", ok, tt.wantFQDN != "")
+			}
+			if ok && c.ToHostname != tt.wantFQDN {
+				t.Errorf("ToHostname = %q, want %q", c.ToHostname, tt.wantFQDN)
+			}
+		})
+	}
+}
+
+// serviceRunner returns a Runner for the toPod with the services in its namespace
+func serviceRunner(toPod *v1.Pod, services ...v1.Service) *Runner {
+	objects := []runtime.Object{}
+	for i := range services {
+		objects = append(objects, &services[i])
+	}
+	return &Runner{
+		toPod:          toPod,
+		toPodNamespace: toPod.Namespace,
+		clientset:      fake.NewSimpleClientset(objects...),
+		logFilter:      DefaultLogFilter,
+	}
+}
+
+// selectorService is a Service in `sock-shop` with the selector
+func selectorService(name string, selector map[string]string) v1.Service {
+	return v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "sock-shop"},
+		Spec:       v1.ServiceSpec{Selector: selector},
+	}
+}
+
+func TestFindToPodServiceFQDNsSelector(t *testing.T) {
+	toPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "user-db-0",
+		Namespace: "sock-shop",
+		Labels:    map[string]string{"app": "user-db", "tier": "db", "pod-template-hash": "b8dfb847c"},
+	}}
+	tests := []struct {
+		name     string
+		services []v1.Service
+		want     []string
+	}{
+		{
+			name:     "two-label selector fully matching",
+			services: []v1.Service{selectorService("user-db", map[string]string{"app": "user-db", "tier": "db"})},
+			want:     []string{"user-db.sock-shop.svc.cluster.local."},
+		},
+		{
+			name:     "two-label selector partially matching",
+			services: []v1.Service{selectorService("user-db", map[string]string{"app": "user-db", "tier": "cache"})},
+			want:     []string{},
+		},
+		{
+			name:     "selector with a label the pod doesn't have",
+			services: []v1.Service{selectorService("user-db", map[string]string{"app": "user-db", "zone": "a"})},
+			want:     []string{},
+		},
+		{
+			name:     "no selector",
+			services: []v1.Service{selectorService("user-db", nil)},
+			want:     []string{},
+		},
+		{
+			name: "multiple services",
+			services: []v1.Service{
+				selectorService("user-db", map[string]string{"app": "user-db", "tier": "db"}),
+				selectorService("user-db-metrics", map[string]string{"app": "user-db"}),
+				selectorService("carts-db", map[string]string{"app": "carts-db", "tier": "db"}),
+			},
+			want: []string{"user-db.sock-shop.svc.cluster.local.", "user-db-metrics.sock-shop.svc.cluster.local."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := serviceRunner(toPod, tt.services...).findToPodServiceFQDNs()
+			if err != nil {
+				t.Fatalf("findToPodServiceFQDNs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findToPodServiceFQDNs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessConnectionLogTargetRefNamespace(t *testing.T) {
+	const fqdn = "user-db.sock-shop.svc.cluster.local."
+	tests := []struct {
+		name          string
+		endpoints     v1.Endpoints
+		wantNamespace string
+	}{
+		{
+			name:          "TargetRef with a namespace",
+			endpoints:     endpoints("orders", "orders", podAddress("10.42.0.8", "orders-0", "orders")),
+			wantNamespace: "orders",
+		},
+		{
+			name:          "TargetRef without a namespace",
+			endpoints:     endpoints("orders", "orders", podAddress("10.42.0.8", "orders-0", "")),
+			wantNamespace: "orders",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := connectionRunner([]string{fqdn}, []string{"orders", "sock-shop"}, tt.endpoints)
+
+			c, err, ok := parseLogMsg(queryLog("10.42.0.8", fqdn), DefaultLogFilter)
+			if err != nil || !ok {
+				t.Fatalf("parseLogMsg() = %v, %v", err, ok)
+			}
+			if err := r.processConnectionLog(c); err != nil {
+				t.Fatalf("processConnectionLog() error = %v", err)
+			}
+
+			mappings := r.hostnamePodMapping[fqdn]
+			if len(mappings) != 1 {
+				t.Fatalf("got %d callers, want 1", len(mappings))
+			}
+			if mappings[0].podname != "orders-0" || mappings[0].namespace != tt.wantNamespace {
+				t.Errorf("caller = %s/%s, want %s/orders-0", mappings[0].namespace, mappings[0].podname, tt.wantNamespace)
+			}
+			if got := r.connections()[0].FromNamespace; got != tt.wantNamespace {
+				t.Errorf("connection namespace = %q, want %q", got, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func TestFindToPodServiceFQDNsHeadless(t *testing.T) {
+	selector := map[string]string{"app": "user-db"}
+	headless := selectorService("user-db", selector)
+	headless.Spec.ClusterIP = v1.ClusterIPNone
+
+	tests := []struct {
+		name         string
+		service      v1.Service
+		hostname     string
+		subdomain    string
+		want         []string
+		wantHostname string
+	}{
+		{
+			name:         "headless service as the subdomain of the pod",
+			service:      headless,
+			hostname:     "user-db-0",
+			subdomain:    "user-db",
+			want:         []string{"user-db.sock-shop.svc.cluster.local.", "user-db-0.user-db.sock-shop.svc.cluster.local."},
+			wantHostname: "user-db-0.user-db.sock-shop.svc.cluster.local.",
+		},
+		{
+			name:    "headless service without a pod hostname",
+			service: headless,
+			want:    []string{"user-db.sock-shop.svc.cluster.local."},
+		},
+		{
+			name:      "headless service which isn't the subdomain of the pod",
+			service:   headless,
+			hostname:  "user-db-0",
+			subdomain: "user-db-peers",
+			want:      []string{"user-db.sock-shop.svc.cluster.local."},
+		},
+		{
+			name:      "service with a cluster IP",
+			service:   selectorService("user-db", selector),
+			hostname:  "user-db-0",
+			subdomain: "user-db",
+			want:      []string{"user-db.sock-shop.svc.cluster.local."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toPod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "user-db-0", Namespace: "sock-shop", Labels: selector},
+				Spec:       v1.PodSpec{Hostname: tt.hostname, Subdomain: tt.subdomain},
+			}
+			r := serviceRunner(toPod, tt.service)
+			got, err := r.findToPodServiceFQDNs()
+			if err != nil {
+				t.Fatalf("findToPodServiceFQDNs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findToPodServiceFQDNs() = %v, want %v", got, tt.want)
+			}
+			if r.toPodHostnameFQDN != tt.wantHostname {
+				t.Errorf("toPodHostnameFQDN = %q, want %q", r.toPodHostnameFQDN, tt.wantHostname)
+			}
+			if tt.wantHostname == "" {
+				return
+			}
+
+			// a caller querying the hostname FQDN connects to the pod IP directly
+			r.hostnamePodMapping = map[string][]*Mapping{}
+			r.toPodServiceFQDNs = got
+			r.allNamespaces = &v1.NamespaceList{}
+			r.allEndpoints = map[string]*v1.EndpointsList{}
+			r.podsByIP = map[string]*Mapping{"10.42.0.8": {podname: "front-end-0", namespace: "sock-shop"}}
+			c, err, ok := parseLogMsg(queryLog("10.42.0.8", tt.wantHostname), DefaultLogFilter)
+			if err != nil || !ok {
+				t.Fatalf("parseLogMsg() = %v, %v", err, ok)
+			}
+			if err := r.processConnectionLog(c); err != nil {
+				t.Fatalf("processConnectionLog() error = %v", err)
+			}
+			if len(r.hostnamePodMapping[tt.wantHostname]) != 1 {
+				t.Errorf("the query for %s isn't a connection to the pod", tt.wantHostname)
+			}
+			if w := serviceFromFQDN(tt.wantHostname, DefaultLogFilter.fqdnSuffix()); w.Name != "user-db" || w.Namespace != "sock-shop" {
+				t.Errorf("serviceFromFQDN() = %v, want service/user-db in sock-shop", w)
+			}
+		})
+	}
+}
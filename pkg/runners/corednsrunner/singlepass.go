@@ -0,0 +1,163 @@
+package corednsrunner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// singlePassIdle is how long the log stream of a CoreDNS pod can stay quiet
+// after a relevant log was found before the stream is considered caught up
+const singlePassIdle = 2 * time.Second
+
+// followConnectionLogs reads the logs of the CoreDNS pods once, parsing them
+// while waiting for the relevant logs (instead of waitForLogs + parseConnectionLogs)
+// The logs of a pod are followed until a relevant log was found and the stream
+// caught up with the present (or stayed quiet for singlePassIdle)
+func (r *Runner) followConnectionLogs() ([]*ConnectionLog, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.waitForLogsDuration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var e error
+	connLogsByPod := make([][]*ConnectionLog, len(r.coreDNSPods.Items))
+
+	for i, pod := range r.coreDNSPods.Items {
+		i, pod := i, pod
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connLogs, err := r.followPodConnectionLogs(ctx, pod, &mu)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				e = err
+				return
+			}
+			connLogsByPod[i] = connLogs
+		}()
+	}
+	wg.Wait()
+	if e != nil {
+		return nil, e
+	}
+
+	connLogList := []*ConnectionLog{}
+	for _, c := range connLogsByPod {
+		connLogList = append(connLogList, c...)
+	}
+	return connLogList, nil
+}
+
+// followPodConnectionLogs parses the logs of a CoreDNS pod from the beginning
+// mu guards the state shared between the pods e.g., the scanned line counts
+func (r *Runner) followPodConnectionLogs(parent context.Context, pod v1.Pod, mu *sync.Mutex) ([]*ConnectionLog, error) {
+	tStart := time.Now()
+	ctx, cancel, timeout := r.podLogContext(parent)
+	defer cancel()
+
+	// timestamps tell when the stream caught up with the present
+	req := r.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{Follow: true, Timestamps: true})
+	stream, err := req.Stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stream)
+		// scanner has a limitation where it can read max 65536 characters
+		// More info and solution: https://stackoverflow.com/a/16615559/6874596
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		// the stream is closed when the timeout hits
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			scanErr <- err
+		}
+	}()
+
+	log.Debugf("%s: parsing the coredns pod logs until the relevant logs are found\n", pod.Name)
+	connLogList := []*ConnectionLog{}
+	idle := time.NewTimer(singlePassIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				select {
+				case err := <-scanErr:
+					return nil, err
+				default:
+				}
+				if len(connLogList) == 0 && r.ctx.Err() == nil {
+					return nil, fmt.Errorf(logNotFound, pod.Name, timeout)
+				}
+				return connLogList, nil
+			}
+
+			ts, t, _ := strings.Cut(l, " ")
+			logTime, timeErr := time.Parse(time.RFC3339Nano, ts)
+			mu.Lock()
+			r.linesScanned[pod.Name]++
+			if r.rawLogs != nil {
+				r.rawLogs[pod.Name] = append(r.rawLogs[pod.Name], t)
+			}
+			r.countRcode(t)
+			if timeErr == nil {
+				r.lastLogTimes[pod.Name] = logTime
+			}
+			mu.Unlock()
+
+			c, success, err := r.logParser.Parse(t)
+			if err != nil {
+				return nil, err
+			}
+			if success {
+				if timeErr == nil {
+					c.Time = logTime
+				}
+				connLogList = append(connLogList, c)
+			}
+
+			if len(connLogList) > 0 {
+				if timeErr == nil && !logTime.Before(tStart) {
+					log.Debugf("%s: relevant logs found :)\n", pod.Name)
+					return connLogList, nil
+				}
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(singlePassIdle)
+		case <-idle.C:
+			if len(connLogList) > 0 {
+				log.Debugf("%s: relevant logs found :)\n", pod.Name)
+				return connLogList, nil
+			}
+			idle.Reset(singlePassIdle)
+		case <-ctx.Done():
+			// interrupted, the logs so far are used
+			if len(connLogList) > 0 || r.ctx.Err() != nil {
+				return connLogList, nil
+			}
+			log.Infof("%s: giving up... :(\n", pod.Name)
+			log.Errorf(logNotFound, pod.Name, timeout)
+			return nil, fmt.Errorf(logNotFound, pod.Name, timeout)
+		}
+	}
+}
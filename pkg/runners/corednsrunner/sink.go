@@ -0,0 +1,90 @@
+package corednsrunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sinkBufferSize is the number of events buffered before new events are dropped
+const sinkBufferSize = 1000
+
+// ConnectionEvent is what gets sent to a Sink for every new connection
+type ConnectionEvent struct {
+	Context         string `json:"context,omitempty"`
+	TargetPod       string `json:"targetPod"`
+	TargetNamespace string `json:"targetNamespace"`
+	Connection
+}
+
+// Sink POSTs every new connection as a single line of JSON (NDJSON) to an HTTP endpoint
+// Events are sent in the background. If the endpoint can't keep up,
+// new events are dropped. Errors are logged and the events are not retried
+type Sink struct {
+	url    string
+	client *http.Client
+	events chan ConnectionEvent
+	wg     sync.WaitGroup
+}
+
+// NewSink creates a Sink which POSTs to url with the timeout per request
+func NewSink(url string, timeout time.Duration) *Sink {
+	s := &Sink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		events: make(chan ConnectionEvent, sinkBufferSize),
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for e := range s.events {
+			if err := s.post(e); err != nil {
+				log.Errorf("couldn't send the connection to the sink: %v", err)
+			}
+		}
+	}()
+	return s
+}
+
+// send queues the event without blocking
+func (s *Sink) send(e ConnectionEvent) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.events <- e:
+	default:
+		log.Warnf("sink is not keeping up, dropping the connection from %s/%s", e.FromNamespace, e.FromPod)
+	}
+}
+
+func (s *Sink) post(e ConnectionEvent) error {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(e); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/x-ndjson", &b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// Close waits for the queued events to be sent
+func (s *Sink) Close() {
+	if s == nil {
+		return
+	}
+	close(s.events)
+	s.wg.Wait()
+}
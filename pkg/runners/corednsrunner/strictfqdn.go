@@ -0,0 +1,52 @@
+package corednsrunner
+
+import (
+	"strings"
+	"unicode"
+)
+
+// validDNSName checks whether the name (with or without the trailing dot) is a well-formed DNS name
+// i.e., at most 253 characters of labels with 1 to 63 letters, digits, `-` or `_`
+// which don't start or end with `-` (`_` is for SRV records e.g., `_http._tcp.user-db`)
+func validDNSName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// strictFQDNMatch checks whether the cluster suffix in the log ends a well-formed FQDN
+// at a whitespace boundary e.g., it rejects `user-db.sock-shop.svc.cluster.local.example.com.`,
+// `user-db.sock-shop.svc.cluster.localhost` or `us%er-db.sock-shop.svc.cluster.local.`
+// A log without the cluster suffix (e.g., of an extra FQDN) is checked by its query name
+func (f LogFilter) strictFQDNMatch(rawText string) bool {
+	suffix := f.clusterSuffix()
+	si := f.clusterSuffixIndex(rawText)
+	if si < 0 {
+		qname, _, ok := queryRcode(rawText, f.LogLevelMarker)
+		return ok && validDNSName(qname)
+	}
+
+	end := si + len(suffix)
+	if end < len(rawText) && rawText[end] == '.' {
+		end++
+	}
+	if end < len(rawText) && !unicode.IsSpace(rune(rawText[end])) {
+		return false
+	}
+
+	start := strings.LastIndex(rawText[:si], " ") + 1
+	return validDNSName(rawText[start:end])
+}
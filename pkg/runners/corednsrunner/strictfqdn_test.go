@@ -0,0 +1,113 @@
+package corednsrunner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLogMsgStrictFQDN(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		// wantLoose is whether the log is kept without --strict-fqdn
+		wantLoose bool
+		// wantStrict is whether the log is kept with --strict-fqdn
+		wantStrict bool
+		wantFQDN   string
+	}{
+		{
+			name:       "well-formed FQDN with the trailing dot",
+			log:        queryLog("10.42.2.90", "user-db.sock-shop.svc.cluster.local."),
+			wantLoose:  true,
+			wantStrict: true,
+			wantFQDN:   "user-db.sock-shop.svc.cluster.local.",
+		},
+		{
+			name:       "well-formed FQDN without the trailing dot",
+			log:        queryLog("10.42.2.90", "user-db.sock-shop.svc.cluster.local"),
+			wantLoose:  true,
+			wantStrict: true,
+			wantFQDN:   "user-db.sock-shop.svc.cluster.local.",
+		},
+		{
+			name:       "SRV query",
+			log:        queryLog("10.42.2.90", "_mongo._tcp.user-db.sock-shop.svc.cluster.local."),
+			wantLoose:  true,
+			wantStrict: true,
+			wantFQDN:   "_mongo._tcp.user-db.sock-shop.svc.cluster.local.",
+		},
+		{
+			name:      "cluster suffix inside a longer name",
+			log:       queryLog("10.42.2.90", "user-db.sock-shop.svc.cluster.local.example.com."),
+			wantLoose: true,
+		},
+		{
+			name: "cluster suffix as a prefix of the last label",
+			log:  queryLog("10.42.2.90", "user-db.sock-shop.svc.cluster.localhost."),
+		},
+		{
+			name:      "invalid character",
+			log:       queryLog("10.42.2.90", "us%er-db.sock-shop.svc.cluster.local."),
+			wantLoose: true,
+		},
+		{
+			name:      "label starting with a hyphen",
+			log:       queryLog("10.42.2.90", "-user-db.sock-shop.svc.cluster.local."),
+			wantLoose: true,
+		},
+		{
+			name:      "label longer than 63 characters",
+			log:       queryLog("10.42.2.90", strings.Repeat("a", 64)+".sock-shop.svc.cluster.local."),
+			wantLoose: true,
+		},
+		{
+			name:      "empty label",
+			log:       queryLog("10.42.2.90", "user-db..svc.cluster.local."),
+			wantLoose: true,
+		},
+		{
+			name:      "cluster suffix outside the query",
+			log:       `[INFO] 10.42.2.90:59003 - 9687 "A IN example.com. udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s from=user-db.sock-shop.svc.cluster.local.host`,
+			wantLoose: true,
+		},
+		{
+			// the rcode is cut off
+			name: "truncated after the query name",
+			log:  `[INFO] 10.42.2.90:59003 - 9687 "A IN user-db.sock-shop.svc.cluster.local.`,
+		},
+		{
+			name: "truncated inside the cluster suffix",
+			log:  `[INFO] 10.42.2.90:59003 - 9687 "A IN user-db.sock-shop.svc.cluster.lo`,
+		},
+		{
+			name: "truncated before the IP",
+			log:  `[INFO] user-db.sock-shop.svc.cluster.local.`,
+		},
+		{
+			name: "empty",
+			log:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loose := DefaultLogFilter
+			if got := relevantLogMsg(tt.log, loose); got != tt.wantLoose {
+				t.Errorf("relevantLogMsg() without --strict-fqdn = %v, want %v", got, tt.wantLoose)
+			}
+
+			strict := DefaultLogFilter
+			strict.StrictFQDN = true
+			c, err, ok := parseLogMsg(tt.log, strict)
+			if err != nil {
+				t.Fatalf("parseLogMsg() error = %v", err)
+			}
+			if ok != tt.wantStrict {
+				t.Fatalf("parseLogMsg() with --strict-fqdn kept the log = %v, want %v", ok, tt.wantStrict)
+			}
+			if ok && c.ToHostname != tt.wantFQDN {
+				t.Errorf("ToHostname = %q, want %q", c.ToHostname, tt.wantFQDN)
+			}
+		})
+	}
+}
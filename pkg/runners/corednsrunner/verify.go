@@ -0,0 +1,147 @@
+package corednsrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReadNetworkPolicy reads a NetworkPolicy from a YAML (or JSON) file
+// e.g., for InitConfig.VerifyPolicy
+func ReadNetworkPolicy(path string) (*networkingv1.NetworkPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// yaml.v3 doesn't know the json tags of the K8s types, so go through JSON
+	v := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("couldn't parse `%s`: %w", path, err)
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	np := &networkingv1.NetworkPolicy{}
+	if err := json.Unmarshal(j, np); err != nil {
+		return nil, fmt.Errorf("couldn't parse `%s` as a NetworkPolicy: %w", path, err)
+	}
+	if np.Kind != "NetworkPolicy" {
+		return nil, fmt.Errorf("`%s` is a `%s`, expected a NetworkPolicy", path, np.Kind)
+	}
+	return np, nil
+}
+
+// verifiedCaller is an observed caller of the toPod with the verdict of the verifyPolicy
+type verifiedCaller struct {
+	pod      string
+	services []string
+	allowed  bool
+}
+
+// printPolicyVerification prints which observed callers the verifyPolicy allows or blocks
+// and which of its ingress peers no observed caller matched
+// The ports aren't verified because the DNS logs don't have the ports of the connections
+func (r *Runner) printPolicyVerification() error {
+	np := r.verifyPolicy
+	namespace := np.Namespace
+	if namespace == "" {
+		namespace = r.toPod.Namespace
+	}
+	anonNp := r.anonymizer.networkPolicy(np)
+	toPod := fmt.Sprintf("pod %s (ns %s)", r.anonymizer.Pod(r.toPod.Name), r.anonymizer.Namespace(r.toPod.Namespace))
+
+	printBanner(fmt.Sprintf("VERIFYING NetworkPolicy %s", anonNp.Name))
+	if namespace != r.toPod.Namespace || !selectorMatches(&np.Spec.PodSelector, r.toPod.GetLabels()) {
+		fmt.Printf("NetworkPolicy %s doesn't select %s, so it doesn't affect its ingress\n", anonNp.Name, toPod)
+		return nil
+	}
+	if !hasPolicyType(*np, networkingv1.PolicyTypeIngress) {
+		fmt.Printf("NetworkPolicy %s doesn't restrict the ingress of %s (policyTypes: %s)\n", anonNp.Name, toPod, strings.Join(policyTypes(*np), ","))
+		return nil
+	}
+
+	callers := map[string]*verifiedCaller{}
+	// matched[i][j] is set if an observed caller matched the peer j of the ingress rule i
+	matched := make([][]bool, len(np.Spec.Ingress))
+	for i, rule := range np.Spec.Ingress {
+		matched[i] = make([]bool, len(rule.From))
+	}
+
+	for hostname, mappings := range r.hostnamePodMapping {
+		for _, m := range mappings {
+			key := r.anonymizer.Namespace(m.namespace) + "/" + r.anonymizer.Pod(m.podname)
+			c, ok := callers[key]
+			if !ok {
+				pod, err := r.clientset.CoreV1().Pods(m.namespace).Get(context.Background(), m.podname, metav1.GetOptions{})
+				if err != nil {
+					return fmt.Errorf("couldn't get the caller pod %s: %w", key, err)
+				}
+				c = &verifiedCaller{pod: key, allowed: r.policyAllows(np, namespace, pod, matched)}
+				callers[key] = c
+			}
+			if !contains(c.services, r.anonymizer.fqdn(hostname)) {
+				c.services = append(c.services, r.anonymizer.fqdn(hostname))
+			}
+		}
+	}
+
+	keys := []string{}
+	for k := range callers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	blocked := 0
+	for _, k := range keys {
+		c := callers[k]
+		sort.Strings(c.services)
+		verdict := "allowed"
+		if !c.allowed {
+			verdict = "blocked"
+			blocked++
+		}
+		fmt.Printf("%s: pod %s via svc: %s\n", verdict, c.pod, strings.Join(c.services, ", "))
+	}
+
+	for i, rule := range anonNp.Spec.Ingress {
+		for j, peer := range rule.From {
+			if !matched[i][j] {
+				fmt.Printf("unobserved peer: %s (ingress rule %d)\n", describePeers([]networkingv1.NetworkPolicyPeer{peer}), i+1)
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\n%d observed caller(s), %d blocked by NetworkPolicy %s (ports not verified)\n", len(callers), blocked, anonNp.Name)
+	return nil
+}
+
+// policyAllows returns true if any ingress rule of the NetworkPolicy in the namespace allows the pod
+// and marks the peers matching the pod in matched
+func (r *Runner) policyAllows(np *networkingv1.NetworkPolicy, namespace string, pod *v1.Pod, matched [][]bool) bool {
+	allowed := false
+	for i, rule := range np.Spec.Ingress {
+		if len(rule.From) == 0 {
+			// no peers means anywhere
+			allowed = true
+			continue
+		}
+		for j, peer := range rule.From {
+			if r.peersInclude([]networkingv1.NetworkPolicyPeer{peer}, namespace, pod) {
+				matched[i][j] = true
+				allowed = true
+			}
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,52 @@
+package corednsrunner
+
+import "fmt"
+
+const (
+	// WarningStalePod is when the target pod didn't exist and a current pod of its workload was used
+	WarningStalePod = "StalePod"
+	// WarningSampledCoreDNSPods is when the logs of only some CoreDNS pods were read
+	WarningSampledCoreDNSPods = "SampledCoreDNSPods"
+	// WarningNoRelevantLogs is when the logs had no relevant logs even though waiting found one
+	WarningNoRelevantLogs = "NoRelevantLogs"
+	// WarningNoConnection is when no connection showed up within WaitForConnection
+	WarningNoConnection = "NoConnection"
+	// WarningAPIError is when a K8s API call failed and the analysis continued without its result
+	WarningAPIError = "APIError"
+	// WarningNetworkPolicy is when the suggested NetworkPolicy is likely not what's expected
+	// e.g., it selects all the pods in the namespace or it isn't suggested because of MaxPeers
+	WarningNetworkPolicy = "NetworkPolicy"
+	// WarningDNSEgress is when the DNS egress NetworkPolicies fall back to the CoreDNS selector
+	WarningDNSEgress = "DNSEgress"
+	// WarningInterrupted is when waiting for the logs was cancelled (see InitConfig.Ctx)
+	WarningInterrupted = "Interrupted"
+)
+
+// Warning is something which went wrong without failing the analysis
+// e.g., for embedders to surface in their UI instead of parsing the logs
+type Warning struct {
+	// Code is one of the Warning* constants
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Warnings returns the warnings collected so far
+func (r *Runner) Warnings() []Warning {
+	r.warningsMu.Lock()
+	defer r.warningsMu.Unlock()
+	return append([]Warning{}, r.warnings...)
+}
+
+// warnf logs the warning and collects it
+func (r *Runner) warnf(code string, format string, args ...interface{}) {
+	log.Warnf(format, args...)
+	r.addWarning(code, format, args...)
+}
+
+// addWarning collects the warning without logging it
+// e.g., if it is already logged at another level
+func (r *Runner) addWarning(code string, format string, args ...interface{}) {
+	r.warningsMu.Lock()
+	defer r.warningsMu.Unlock()
+	r.warnings = append(r.warnings, Warning{Code: code, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,229 @@
+package corednsrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// peerExpiryInterval is how often the callers are checked against the peer TTL
+// while following the logs
+const peerExpiryInterval = time.Second
+
+// watchConnectionLogs follows the logs of all coredns pods
+// and prints connections which haven't been seen before
+// It runs until the log streams are closed or ctx is done
+// or (with stopAtFirstConnection) until the first new connection
+func (r *Runner) watchConnectionLogs(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.stopWatching = cancel
+
+	if r.watchMode == WatchModePoll {
+		return r.pollConnectionLogs(ctx)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var e error
+	// the timestamps would prevent joining the continuation lines
+	timestamps := r.logFilter.ContinuationPrefix == ""
+
+	if r.peerTTL > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(peerExpiryInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					mu.Lock()
+					r.expireStalePeers()
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, pod := range r.coreDNSPods.Items {
+		wg.Add(1)
+		pod := pod
+		mu.Lock()
+		last := r.resumeTime(pod.Name)
+		mu.Unlock()
+		go func() {
+			defer wg.Done()
+			// SinceTime has a precision of seconds, the logs read before are skipped below
+			since := metav1.NewTime(last)
+			req := r.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{Follow: true, SinceTime: &since, Timestamps: timestamps})
+			stream, err := req.Stream(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					mu.Lock()
+					e = err
+					mu.Unlock()
+				}
+				return
+			}
+			defer stream.Close()
+
+			log.Debugf("%s: watching for new connections\n", pod.Name)
+			scanner := r.newLogScanner(stream)
+			for scanner.Scan() {
+				// the logs are followed live
+				logTime, t := time.Now(), scanner.Text()
+				if timestamps {
+					logTime, t = splitLogTimestamp(t)
+					if !logTime.After(last) {
+						// already read
+						continue
+					}
+					mu.Lock()
+					r.lastLogTimes[pod.Name] = logTime
+					mu.Unlock()
+				}
+
+				c, success, err := r.logParser.Parse(t)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+				if !success {
+					continue
+				}
+				c.Time = logTime
+
+				mu.Lock()
+				err = r.processConnectionLog(c)
+				mu.Unlock()
+				if err != nil {
+					log.Error(err)
+				}
+			}
+
+			if err := scanner.Err(); err != nil && ctx.Err() == nil {
+				mu.Lock()
+				e = err
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return e
+}
+
+// resumeTime returns when watching the logs of the CoreDNS pod resumes from
+// i.e., its last log line read before (or now if its logs weren't read)
+func (r *Runner) resumeTime(pod string) time.Time {
+	if t, ok := r.lastLogTimes[pod]; ok {
+		return t
+	}
+	return time.Now()
+}
+
+// pollConnectionLogs fetches the logs of all coredns pods written since
+// the previous poll every pollInterval and prints connections which
+// haven't been seen before. A failed poll is logged and retried
+// in the next one. It runs until ctx is cancelled
+func (r *Runner) pollConnectionLogs(ctx context.Context) error {
+	interval := r.pollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	// from the earliest of the logs read before so that the logs
+	// written since then aren't missed
+	since := metav1.NewTime(time.Now())
+	for _, pod := range r.coreDNSPods.Items {
+		if t := r.resumeTime(pod.Name); t.Before(since.Time) {
+			since = metav1.NewTime(t)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// SinceTime has a precision of seconds, so the logs around
+		// the previous poll show up twice. parseConnectionLogs skips
+		// the lines logged before the last line read from the CoreDNS
+		// pod (only with timestamps, see LogFilter.ContinuationPrefix)
+		next := metav1.NewTime(time.Now())
+		log.Debugf("polling for new connections since %s", since)
+		connLogList, err := r.parseConnectionLogs(&since)
+		if err != nil {
+			log.Errorf("couldn't poll the CoreDNS logs: %v", err)
+			continue
+		}
+		since = next
+
+		for _, c := range connLogList {
+			if err := r.processConnectionLog(c); err != nil {
+				log.Error(err)
+			}
+		}
+		if r.peerTTL > 0 {
+			r.expireStalePeers()
+		}
+	}
+}
+
+// expireStalePeers removes the callers not seen for peerTTL from hostnamePodMapping
+// so that they are not used in the suggested NetworkPolicy anymore
+func (r *Runner) expireStalePeers() {
+	for hostname, mappings := range r.hostnamePodMapping {
+		active := []*Mapping{}
+		for _, m := range mappings {
+			if time.Since(m.lastSeen) < r.peerTTL {
+				active = append(active, m)
+				continue
+			}
+
+			log.Debugf("pod %s in ns %s wasn't seen for %s, removing it", m.podname, m.namespace, r.peerTTL)
+			if r.printEachConnection() {
+				fmt.Printf("expired pod: %s, ns: %s via svc: %s (not seen for %s)\n", r.anonymizer.Pod(m.podname), r.anonymizer.Namespace(m.namespace), r.anonymizer.fqdn(hostname), r.peerTTL)
+			}
+		}
+
+		if len(active) == 0 {
+			delete(r.hostnamePodMapping, hostname)
+			continue
+		}
+		r.hostnamePodMapping[hostname] = active
+	}
+}
+
+// awaitConnection follows the logs until the first connection to the pod
+// or until waitForConnection elapses
+func (r *Runner) awaitConnection() error {
+	fmt.Fprintf(os.Stderr, "no connections to the pod yet, waiting up to %s for one...\n", r.waitForConnection)
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.waitForConnection)
+	defer cancel()
+
+	stopAtFirstConnection := r.stopAtFirstConnection
+	r.stopAtFirstConnection = true
+	defer func() { r.stopAtFirstConnection = stopAtFirstConnection }()
+
+	if err := r.watchConnectionLogs(ctx); err != nil {
+		return err
+	}
+	if len(r.hostnamePodMapping) == 0 {
+		r.warnf(WarningNoConnection, "no connection to the pod in %s", r.waitForConnection)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package corednsrunner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollSkipsLogsReadBefore(t *testing.T) {
+	const fqdn = "user-db.sock-shop.svc.cluster.local."
+	// the queries from the caller on distinct ports so that the lines differ
+	queries := []string{}
+	for i := 0; i < 3; i++ {
+		queries = append(queries, fmt.Sprintf(`[INFO] 10.42.0.8:%d - 9687 "A IN %s udp 53 false 512" NOERROR qr,aa,rd 146 0.000428325s`, 59003+i, fqdn))
+	}
+
+	tests := []struct {
+		name       string
+		timestamps bool
+	}{
+		{name: "with timestamps", timestamps: true},
+		{name: "without timestamps"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []string{}
+			for i, q := range queries {
+				if tt.timestamps {
+					q = fmt.Sprintf("2026-10-15T10:00:0%d.500000000Z %s", i, q)
+				}
+				lines = append(lines, q)
+			}
+
+			r := connectionRunner([]string{fqdn}, []string{"sock-shop"},
+				endpoints("sock-shop", "front-end", podAddress("10.42.0.8", "front-end-0", "sock-shop")))
+			r.logParser = &CoreDNSLogParser{Filter: r.logFilter}
+			r.lastLogTimes = map[string]time.Time{}
+			r.lastLogLines = map[string]string{}
+			r.linesScanned = map[string]int{}
+			r.rcodes = map[string]int{}
+
+			// SinceTime has a precision of seconds, so every poll reads the lines before again
+			polls := []struct {
+				lines     []string
+				wantCount int
+			}{
+				{lines: lines[:2], wantCount: 2},
+				{lines: lines[:2], wantCount: 2},
+				{lines: lines[1:], wantCount: 3},
+				{lines: lines[2:], wantCount: 3},
+			}
+			for i, p := range polls {
+				logs := strings.Join(p.lines, "\n") + "\n"
+				connLogList, err := r.scanConnectionLogs("coredns-0", strings.NewReader(logs), tt.timestamps, i > 0)
+				if err != nil {
+					t.Fatalf("poll %d: scanConnectionLogs() error = %v", i, err)
+				}
+				for _, c := range connLogList {
+					if err := r.processConnectionLog(c); err != nil {
+						t.Fatalf("poll %d: processConnectionLog() error = %v", i, err)
+					}
+				}
+
+				m := r.hostnamePodMapping[fqdn]
+				if len(m) != 1 {
+					t.Fatalf("poll %d: %d callers, want 1", i, len(m))
+				}
+				if m[0].count != p.wantCount {
+					t.Errorf("poll %d: count = %d, want %d", i, m[0].count, p.wantCount)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package corednsrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Workload identifies a K8s object e.g., a Deployment or a Service
+type Workload struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(w.Kind), w.Name)
+}
+
+// WorkloadEdge is an incoming connection collapsed
+// from pod level to workload level
+// e.g., deployment/front-end -> service/user-db
+type WorkloadEdge struct {
+	From Workload `json:"from"`
+	To   Workload `json:"to"`
+}
+
+// Connection is a pod level incoming connection
+type Connection struct {
+	FromPod       string `json:"fromPod"`
+	FromNamespace string `json:"fromNamespace"`
+	ToFQDN        string `json:"toFQDN"`
+	// QueryID and Transport are of the first query seen from the pod
+	QueryID   string `json:"queryID,omitempty"`
+	Transport string `json:"transport,omitempty"`
+	// Relation is how the calling pod relates to the target
+	// One of: RelationCaller, RelationSibling, RelationSelf
+	Relation string `json:"relation"`
+	// Transports is the number of queries per transport (set with GroupByProtocol)
+	Transports map[string]int `json:"transports,omitempty"`
+	// LastSeen is when the pod last queried the target (not set if the logs had no timestamps)
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// Unresolved is a connection from an IP which couldn't be matched to a pod
+// e.g., from a host network pod or from outside the cluster
+type Unresolved struct {
+	IP     string `json:"ip"`
+	Port   string `json:"port"`
+	ToFQDN string `json:"toFQDN"`
+	// Node is set if the IP is a node IP i.e., the query came from
+	// the node or from one of the HostNetworkPods running on it
+	Node            string   `json:"node,omitempty"`
+	HostNetworkPods []string `json:"hostNetworkPods,omitempty"`
+}
+
+// addUnresolved records a connection from an IP which couldn't be matched to a pod
+// Only the first port seen from the IP for the FQDN is kept
+func (r *Runner) addUnresolved(c *ConnectionLog) {
+	for _, u := range r.unresolved {
+		if u.IP == c.FromIP && u.ToFQDN == c.ToHostname {
+			return
+		}
+	}
+
+	u := Unresolved{IP: c.FromIP, Port: c.FromPort, ToFQDN: c.ToHostname}
+	if node, ok := r.nodesByIP[c.FromIP]; ok {
+		u.Node = node
+		u.HostNetworkPods = r.hostNetworkPods[node]
+	}
+	r.unresolved = append(r.unresolved, u)
+
+	if r.printEachConnection() {
+		fmt.Printf("ip: %s %s via svc: %s\n", u.IP, r.describeUnresolved(u), r.anonymizer.fqdn(u.ToFQDN))
+	}
+}
+
+// describeUnresolved describes where the unresolved connection came from
+func (r *Runner) describeUnresolved(u Unresolved) string {
+	if u.Node == "" {
+		return "(unresolved)"
+	}
+	u = r.anonymizer.unresolved(u)
+	if len(u.HostNetworkPods) == 0 {
+		return fmt.Sprintf("(host-network, node %s)", u.Node)
+	}
+
+	return fmt.Sprintf("(host-network, node %s, candidates: %s)", u.Node, strings.Join(u.HostNetworkPods, ", "))
+}
+
+// connections flattens hostnamePodMapping into a sorted list
+func (r *Runner) connections() []Connection {
+	conns := []Connection{}
+	for hostname, mappings := range r.hostnamePodMapping {
+		for _, m := range mappings {
+			conns = append(conns, Connection{
+				FromPod:       m.podname,
+				FromNamespace: m.namespace,
+				ToFQDN:        hostname,
+				QueryID:       m.queryID,
+				Transport:     m.transport,
+				Relation:      r.relation(m.podname, m.namespace),
+				Transports:    r.groupedTransports(m),
+				LastSeen:      m.LastQueried(),
+			})
+		}
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].ToFQDN != conns[j].ToFQDN {
+			return conns[i].ToFQDN < conns[j].ToFQDN
+		}
+		if conns[i].FromNamespace != conns[j].FromNamespace {
+			return conns[i].FromNamespace < conns[j].FromNamespace
+		}
+		return conns[i].FromPod < conns[j].FromPod
+	})
+
+	return conns
+}
+
+// workloadEdges resolves the owners of the pods in hostnamePodMapping
+// and collapses pod level mappings into deduplicated workload level edges
+func (r *Runner) workloadEdges() ([]WorkloadEdge, error) {
+	edges := []WorkloadEdge{}
+	seen := map[WorkloadEdge]bool{}
+
+	for _, c := range r.connections() {
+		if c.FromPod == "" {
+			// couldn't resolve the pod from the connection log
+			continue
+		}
+
+		from, err := r.podOwner(c.FromPod, c.FromNamespace)
+		if err != nil {
+			return nil, err
+		}
+
+		e := WorkloadEdge{From: *from, To: serviceFromFQDN(c.ToFQDN, r.logFilter.fqdnSuffix())}
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		edges = append(edges, e)
+	}
+
+	return edges, nil
+}
+
+// podOwner walks up the owner references of a pod
+// e.g., pod -> ReplicaSet -> Deployment and returns the top-most owner
+// A pod without a controller is its own workload and so is a pod
+// which doesn't exist anymore (e.g., it was deleted after it queried CoreDNS)
+// or whose owners can't be looked up
+func (r *Runner) podOwner(podname, namespace string) (*Workload, error) {
+	key := namespace + "/" + podname
+	if w, ok := r.podOwners[key]; ok {
+		return w, nil
+	}
+
+	w := &Workload{Kind: "Pod", Name: podname, Namespace: namespace}
+	pod, err := r.clientset.CoreV1().Pods(namespace).Get(context.Background(), podname, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Warnf("pod %s in ns %s not found, using the pod as its workload", podname, namespace)
+		r.addWarning(WarningAPIError, "couldn't find the owner of the pod %s in ns %s: %v", podname, namespace, err)
+		r.podOwners[key] = w
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := r.ownerChain(pod)
+	if err != nil {
+		// e.g., the ReplicaSet or the Job was deleted
+		log.Warnf("couldn't find the owner of the pod %s in ns %s: %v, using the pod as its workload", podname, namespace, err)
+		r.addWarning(WarningAPIError, "couldn't find the owner of the pod %s in ns %s: %v", podname, namespace, err)
+		r.podOwners[key] = w
+		return w, nil
+	}
+	if len(owners) > 0 {
+		w = &owners[len(owners)-1]
+	}
+
+	r.podOwners[key] = w
+	return w, nil
+}
+
+// serviceFromFQDN converts a FQDN like `user-db.sock-shop.svc.cluster.local.`
+// to the Service it points to
+// A FQDN outside the cluster domain (see LogFilter.ExtraFQDNs) is returned as it is
+func serviceFromFQDN(fqdn, fqdnSuffix string) Workload {
+	if !strings.HasSuffix(fqdn, fqdnSuffix) {
+		return Workload{Kind: "FQDN", Name: fqdn}
+	}
+
+	// `<hostname>.<service>.<namespace>` for a pod of a headless service
+	parts := strings.Split(strings.TrimSuffix(fqdn, fqdnSuffix), ".")
+	if len(parts) == 1 {
+		return Workload{Kind: "Service", Name: parts[0]}
+	}
+	return Workload{Kind: "Service", Name: parts[len(parts)-2], Namespace: parts[len(parts)-1]}
+}
+
+// printWorkloadEdges prints workload level connections
+func (r *Runner) printWorkloadEdges(edges []WorkloadEdge) {
+	printBanner("WORKLOAD CONNECTIONS")
+	for _, e := range edges {
+		e = WorkloadEdge{From: r.anonymizer.workload(e.From), To: r.anonymizer.workload(e.To)}
+		fmt.Printf("workload: %s, ns: %s -> %s, ns: %s\n",
+			r.colorize(colorPod, e.From.String()), r.colorize(colorNamespace, e.From.Namespace),
+			r.colorize(colorService, e.To.String()), r.colorize(colorNamespace, e.To.Namespace))
+	}
+}
+
+// printConnectionsTable prints the connections and the unresolved connections
+// in aligned columns sorted by the service, the namespace and the pod
+func (r *Runner) printConnectionsTable() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if r.groupBy == GroupByProtocol {
+		fmt.Fprintln(w, "SOURCE POD\tSOURCE NS\tVIA SERVICE\tTARGET\tRELATION\tPROTOCOL\tQUERIES\tLAST SEEN")
+	} else {
+		fmt.Fprintln(w, "SOURCE POD\tSOURCE NS\tVIA SERVICE\tTARGET\tRELATION\tLAST SEEN")
+	}
+	target := r.anonymizer.Pod(r.toPod.Name)
+	for _, c := range r.connections() {
+		c = r.anonymizer.connection(c)
+		if r.groupBy != GroupByProtocol {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.FromPod, c.FromNamespace, c.ToFQDN, target, c.Relation, lastSeenAge(c.LastSeen))
+			continue
+		}
+		// a row per protocol
+		for _, t := range sortedTransports(c.Transports) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", c.FromPod, c.FromNamespace, c.ToFQDN, target, c.Relation, strings.ToUpper(t), c.Transports[t], lastSeenAge(c.LastSeen))
+		}
+	}
+
+	unresolved := append([]Unresolved{}, r.unresolved...)
+	sort.Slice(unresolved, func(i, j int) bool {
+		if unresolved[i].ToFQDN != unresolved[j].ToFQDN {
+			return unresolved[i].ToFQDN < unresolved[j].ToFQDN
+		}
+		return unresolved[i].IP < unresolved[j].IP
+	})
+	// the relation and the last seen age (and the protocol and queries) aren't known
+	unknown := "-\t-"
+	if r.groupBy == GroupByProtocol {
+		unknown = "-\t-\t-\t-"
+	}
+	for _, u := range unresolved {
+		fmt.Fprintf(w, "%s %s\t-\t%s\t%s\t%s\n", u.IP, r.describeUnresolved(u), r.anonymizer.fqdn(u.ToFQDN), target, unknown)
+	}
+	w.Flush()
+}
+
+// printFanInByNamespace prints the number of distinct caller pods
+// per namespace along with the services they called
+func (r *Runner) printFanInByNamespace() {
+	pods := map[string]map[string]bool{}
+	services := map[string][]string{}
+	for _, c := range r.connections() {
+		if pods[c.FromNamespace] == nil {
+			pods[c.FromNamespace] = map[string]bool{}
+		}
+		pods[c.FromNamespace][c.FromPod] = true
+
+		svc := r.anonymizer.workload(serviceFromFQDN(c.ToFQDN, r.logFilter.fqdnSuffix())).Name
+		if !contains(services[c.FromNamespace], svc) {
+			services[c.FromNamespace] = append(services[c.FromNamespace], svc)
+		}
+	}
+
+	namespaces := []string{}
+	for ns := range pods {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	printBanner("FAN-IN BY NAMESPACE")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPODS\tSERVICES")
+	for _, ns := range namespaces {
+		sort.Strings(services[ns])
+		fmt.Fprintf(w, "%s\t%d\t%s\n", r.anonymizer.Namespace(ns), len(pods[ns]), strings.Join(services[ns], ","))
+	}
+	w.Flush()
+}
+
+// printCompact prints a single line summary of the callers of the toPod
+// e.g., `user-db-b8dfb847c-wvkgf [3 callers / 2 ns]`
+func (r *Runner) printCompact() {
+	callers := map[string]bool{}
+	namespaces := map[string]bool{}
+	for _, c := range r.connections() {
+		callers[c.FromNamespace+"/"+c.FromPod] = true
+		namespaces[c.FromNamespace] = true
+	}
+
+	fmt.Printf("%s [%d callers / %d ns]\n", r.anonymizer.Pod(r.toPod.Name), len(callers), len(namespaces))
+}
+
+// writeOutputDir writes the result and the suggested NetworkPolicy
+// to `<pod-name>.connections.json` and `<pod-name>.policy.yaml` in outputDir
+func (r *Runner) writeOutputDir(edges []WorkloadEdge) error {
+	if err := os.MkdirAll(r.outputDir, 0755); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := r.writeJSON(&b, edges); err != nil {
+		return err
+	}
+	connectionsFile := filepath.Join(r.outputDir, fmt.Sprintf("%s.connections.json", r.anonymizer.Pod(r.toPod.Name)))
+	if err := os.WriteFile(connectionsFile, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	y, err := r.netPolYAML()
+	if err != nil {
+		return err
+	}
+	policyFile := filepath.Join(r.outputDir, fmt.Sprintf("%s.policy.yaml", r.anonymizer.Pod(r.toPod.Name)))
+	if err := os.WriteFile(policyFile, y, 0644); err != nil {
+		return err
+	}
+
+	log.Infof("wrote %s and %s\n", connectionsFile, policyFile)
+	return nil
+}
+
+// findCurrentWorkloadPod finds the workload a pod (which doesn't exist anymore)
+// belonged to using the pod name and returns a current pod of the workload
+// e.g., for the pod `user-db-b8dfb847c-wvkgf` it looks for
+// the ReplicaSet `user-db-b8dfb847c` and then the Deployment `user-db`
+func findCurrentWorkloadPod(clientset kubernetes.Interface, namespace, podname string) (*v1.Pod, *Workload, error) {
+	ctx := context.Background()
+	name := podname
+	for {
+		i := strings.LastIndex(name, "-")
+		if i <= 0 {
+			return nil, nil, fmt.Errorf("couldn't find a workload for the pod %s", podname)
+		}
+		name = name[:i]
+
+		var selector *metav1.LabelSelector
+		var w *Workload
+		if rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+			w = &Workload{Kind: "ReplicaSet", Name: rs.Name, Namespace: namespace}
+			selector = rs.Spec.Selector
+			if ref := metav1.GetControllerOf(rs); ref != nil && ref.Kind == "Deployment" {
+				// the pods of the ReplicaSet might have been rolled too
+				d, err := clientset.AppsV1().Deployments(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+				if err != nil {
+					return nil, nil, err
+				}
+				w = &Workload{Kind: "Deployment", Name: d.Name, Namespace: namespace}
+				selector = d.Spec.Selector
+			}
+		} else if d, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+			w = &Workload{Kind: "Deployment", Name: d.Name, Namespace: namespace}
+			selector = d.Spec.Selector
+		} else if ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+			w = &Workload{Kind: "DaemonSet", Name: ds.Name, Namespace: namespace}
+			selector = ds.Spec.Selector
+		} else if sts, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+			w = &Workload{Kind: "StatefulSet", Name: sts.Name, Namespace: namespace}
+			selector = sts.Spec.Selector
+		} else {
+			continue
+		}
+
+		pod, err := selectorPod(clientset, namespace, selector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", w, err)
+		}
+		return pod, w, nil
+	}
+}
+
+// findWorkloadPod returns a pod of the workload e.g., `deployment/user-db` or `job/my-batch`
+// For a CronJob, a pod of its most recent Job is returned
+// Completed pods (e.g., of a Job) are returned if there are no Running pods
+func findWorkloadPod(clientset kubernetes.Interface, namespace, kind, name string) (*v1.Pod, *Workload, error) {
+	ctx := context.Background()
+	var selector *metav1.LabelSelector
+	var w *Workload
+	switch strings.ToLower(kind) {
+	case "pod", "pods", "po":
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return pod, &Workload{Kind: "Pod", Name: name, Namespace: namespace}, nil
+	case "deployment", "deployments", "deploy":
+		d, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		w = &Workload{Kind: "Deployment", Name: name, Namespace: namespace}
+		selector = d.Spec.Selector
+	case "replicaset", "replicasets", "rs":
+		rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		w = &Workload{Kind: "ReplicaSet", Name: name, Namespace: namespace}
+		selector = rs.Spec.Selector
+	case "statefulset", "statefulsets", "sts":
+		sts, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		w = &Workload{Kind: "StatefulSet", Name: name, Namespace: namespace}
+		selector = sts.Spec.Selector
+	case "daemonset", "daemonsets", "ds":
+		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		w = &Workload{Kind: "DaemonSet", Name: name, Namespace: namespace}
+		selector = ds.Spec.Selector
+	case "job", "jobs":
+		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		w = &Workload{Kind: "Job", Name: name, Namespace: namespace}
+		selector = job.Spec.Selector
+	case "cronjob", "cronjobs", "cj":
+		jobList, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		w = &Workload{Kind: "CronJob", Name: name, Namespace: namespace}
+		var latest *metav1.Time
+		for _, job := range jobList.Items {
+			ref := metav1.GetControllerOf(&job)
+			if ref == nil || ref.Kind != "CronJob" || ref.Name != name {
+				continue
+			}
+			if latest == nil || latest.Before(&job.CreationTimestamp) {
+				latest = job.CreationTimestamp.DeepCopy()
+				selector = job.Spec.Selector
+			}
+		}
+		if selector == nil {
+			return nil, nil, fmt.Errorf("%s: no jobs found", w)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported kind `%s` (supported: pod, deployment, replicaset, statefulset, daemonset, job, cronjob)", kind)
+	}
+
+	pod, err := selectorPod(clientset, namespace, selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", w, err)
+	}
+	return pod, w, nil
+}
+
+// selectorPod returns a pod matching the selector
+// Running pods are preferred over the others
+func selectorPod(clientset kubernetes.Interface, namespace string, selector *metav1.LabelSelector) (*v1.Pod, error) {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: s.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(podList.Items) == 0 {
+		return nil, fmt.Errorf("no pods found")
+	}
+
+	for _, p := range podList.Items {
+		if p.Status.Phase == v1.PodRunning && p.DeletionTimestamp == nil {
+			return &p, nil
+		}
+	}
+	return &podList.Items[0], nil
+}
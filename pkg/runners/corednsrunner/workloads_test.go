@@ -0,0 +1,81 @@
+package corednsrunner
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// controlledBy sets the controller of the object
+func controlledBy(o metav1.Object, kind, name string) {
+	controller := true
+	o.SetOwnerReferences([]metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}})
+}
+
+func TestPodOwner(t *testing.T) {
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "front-end-7d4b9", Namespace: "sock-shop"}}
+	controlledBy(rs, "Deployment", "front-end")
+	deployed := labeledPod("sock-shop", "front-end-7d4b9-x2v8q", nil)
+	controlledBy(deployed, "ReplicaSet", rs.Name)
+	orphaned := labeledPod("sock-shop", "carts-5f6d8-k9l2p", nil)
+	controlledBy(orphaned, "ReplicaSet", "carts-5f6d8")
+	job := labeledPod("sock-shop", "db-migration-q7w2e", nil)
+	controlledBy(job, "Job", "db-migration")
+
+	tests := []struct {
+		name        string
+		pod         string
+		want        Workload
+		wantWarning bool
+	}{
+		{
+			name: "pod of a Deployment",
+			pod:  deployed.Name,
+			want: Workload{Kind: "Deployment", Name: "front-end", Namespace: "sock-shop"},
+		},
+		{
+			name: "pod without a controller",
+			pod:  "debug",
+			want: Workload{Kind: "Pod", Name: "debug", Namespace: "sock-shop"},
+		},
+		{
+			name:        "deleted pod",
+			pod:         "front-end-7d4b9-gone",
+			want:        Workload{Kind: "Pod", Name: "front-end-7d4b9-gone", Namespace: "sock-shop"},
+			wantWarning: true,
+		},
+		{
+			name:        "pod of a deleted ReplicaSet",
+			pod:         orphaned.Name,
+			want:        Workload{Kind: "Pod", Name: orphaned.Name, Namespace: "sock-shop"},
+			wantWarning: true,
+		},
+		{
+			name:        "pod of a deleted Job",
+			pod:         job.Name,
+			want:        Workload{Kind: "Pod", Name: job.Name, Namespace: "sock-shop"},
+			wantWarning: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{rs, deployed, orphaned, job, labeledPod("sock-shop", "debug", nil)}
+			r := &Runner{clientset: fake.NewSimpleClientset(objects...), podOwners: map[string]*Workload{}}
+
+			got, err := r.podOwner(tt.pod, "sock-shop")
+			if err != nil {
+				t.Fatalf("podOwner() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("podOwner() = %v, want %v", *got, tt.want)
+			}
+			if warned := len(r.warnings) > 0; warned != tt.wantWarning {
+				t.Errorf("warnings = %v, want a warning = %v", r.warnings, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+//go:build integration
+
+// Package integration checks kico end to end against a throwaway kind cluster
+// Run it with `go test -tags integration ./test/integration/` (needs kind, kubectl and docker)
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vadasambar/kico/pkg/runners/corednsrunner"
+)
+
+const (
+	// clusterName can be overridden with KICO_KIND_CLUSTER e.g., to run against an existing cluster
+	clusterName = "kico-integration"
+	namespace   = "sock-shop"
+	// queryLoop queries the FQDNs every second
+	queryLoop = "while true; do for fqdn in %s; do nslookup $fqdn; done; sleep 1; done"
+)
+
+// cluster runs kubectl and kico against the kind cluster
+type cluster struct {
+	t          *testing.T
+	kubeconfig string
+	kico       string
+}
+
+func (c *cluster) run(stdin string, name string, args ...string) string {
+	c.t.Helper()
+	out, err := c.output(stdin, name, args...)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func (c *cluster) output(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+c.kubeconfig)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String() + stderr.String(), err
+	}
+	return stdout.String(), nil
+}
+
+func (c *cluster) kubectl(args ...string) string {
+	c.t.Helper()
+	return c.run("", "kubectl", args...)
+}
+
+// setup creates the kind cluster (unless KICO_KIND_CLUSTER is set) and builds kico
+func setup(t *testing.T) *cluster {
+	for _, bin := range []string{"kind", "kubectl", "docker"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not found: %v", bin, err)
+		}
+	}
+
+	dir := t.TempDir()
+	c := &cluster{t: t, kubeconfig: filepath.Join(dir, "kubeconfig"), kico: filepath.Join(dir, "kico")}
+	c.run("", "go", "build", "-o", c.kico, "../..")
+
+	name := os.Getenv("KICO_KIND_CLUSTER")
+	if name == "" {
+		name = clusterName
+		c.run("", "kind", "create", "cluster", "--name", name, "--kubeconfig", c.kubeconfig, "--wait", "2m")
+		t.Cleanup(func() {
+			if _, err := c.output("", "kind", "delete", "cluster", "--name", name); err != nil {
+				t.Logf("couldn't delete the kind cluster %s: %v", name, err)
+			}
+		})
+	} else {
+		c.run("", "kind", "export", "kubeconfig", "--name", name, "--kubeconfig", c.kubeconfig)
+	}
+	return c
+}
+
+// enableLogPlugin adds the `log` plugin to the Corefile and restarts CoreDNS
+func (c *cluster) enableLogPlugin() {
+	corefile := c.kubectl("get", "configmap", "coredns", "-n", "kube-system", "-o", "jsonpath={.data.Corefile}")
+	if !strings.Contains(corefile, "\n    log\n") {
+		corefile = strings.Replace(corefile, "\n    errors\n", "\n    errors\n    log\n", 1)
+	}
+
+	cm, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]string{"name": "coredns", "namespace": "kube-system"},
+		"data":       map[string]string{"Corefile": corefile},
+	})
+	if err != nil {
+		c.t.Fatalf("couldn't marshal the CoreDNS configmap: %v", err)
+	}
+	c.run(string(cm), "kubectl", "apply", "-f", "-")
+	c.kubectl("rollout", "restart", "deployment", "coredns", "-n", "kube-system")
+	c.kubectl("rollout", "status", "deployment", "coredns", "-n", "kube-system", "--timeout", "2m")
+}
+
+// deploy creates a deployment behind a service querying the FQDNs (if any)
+func (c *cluster) deploy(ns, name, image string, fqdns ...string) {
+	args := []string{"create", "deployment", name, "-n", ns, "--image", image, "--port", "80"}
+	if len(fqdns) > 0 {
+		args = append(args, "--", "sh", "-c", fmt.Sprintf(queryLoop, strings.Join(fqdns, " ")))
+	}
+	c.kubectl(args...)
+	c.kubectl("expose", "deployment", name, "-n", ns, "--port", "80")
+	c.kubectl("rollout", "status", "deployment", name, "-n", ns, "--timeout", "2m")
+}
+
+// result runs kico for the pod until the check passes or the deadline is reached
+// CoreDNS logs the queries only after it has restarted, so the first runs can miss them
+func (c *cluster) result(ns, pod string, check func(*corednsrunner.Result) error) *corednsrunner.Result {
+	c.t.Helper()
+	var err error
+	deadline := time.Now().Add(3 * time.Minute)
+	for time.Now().Before(deadline) {
+		out := c.run("", c.kico, pod, "-n", ns, "-o", corednsrunner.OutputJSON, "--wait-for-logs", "10s")
+		res := &corednsrunner.Result{}
+		if err := json.Unmarshal([]byte(out), res); err != nil {
+			c.t.Fatalf("couldn't unmarshal the output of kico: %v\n%s", err, out)
+		}
+		if err = check(res); err == nil {
+			return res
+		}
+		time.Sleep(5 * time.Second)
+	}
+	c.t.Fatalf("kico didn't report the expected connections to %s/%s: %v", ns, pod, err)
+	return nil
+}
+
+func TestKind(t *testing.T) {
+	c := setup(t)
+	c.enableLogPlugin()
+
+	fqdn := func(service string) string {
+		return fmt.Sprintf("%s.%s.svc.cluster.local.", service, namespace)
+	}
+	c.kubectl("create", "namespace", namespace)
+	c.deploy(namespace, "user-db", "nginx")
+	c.deploy(namespace, "carts", "nginx")
+	// the callers are behind services so that kico can resolve their IPs through the endpoints
+	c.deploy("default", "front-end", "busybox", fqdn("user-db"), fqdn("carts"))
+	c.deploy(namespace, "orders", "busybox", fqdn("user-db"))
+	// a pod without a service can't be resolved and is reported as unresolved
+	c.kubectl("run", "stray", "--image", "busybox", "--restart", "Never", "--", "sh", "-c", fmt.Sprintf(queryLoop, fqdn("carts")))
+	c.kubectl("wait", "--for", "condition=Ready", "pod/stray", "--timeout", "2m")
+	strayIP := c.kubectl("get", "pod", "stray", "-o", "jsonpath={.status.podIP}")
+
+	podOf := func(app string) string {
+		return c.kubectl("get", "pod", "-n", namespace, "-l", "app="+app, "-o", "jsonpath={.items[0].metadata.name}")
+	}
+
+	tests := []struct {
+		name string
+		pod  string
+		// want are the callers as <namespace>/<deployment> -> the FQDN they query
+		want       map[string]string
+		unresolved []string
+	}{
+		{
+			name: "callers from the same and other namespaces",
+			pod:  podOf("user-db"),
+			want: map[string]string{"default/front-end": fqdn("user-db"), namespace + "/orders": fqdn("user-db")},
+		},
+		{
+			name:       "caller without a service",
+			pod:        podOf("carts"),
+			want:       map[string]string{"default/front-end": fqdn("carts")},
+			unresolved: []string{strayIP},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cluster{t: t, kubeconfig: c.kubeconfig, kico: c.kico}
+			c.result(namespace, tt.pod, func(res *corednsrunner.Result) error {
+				got := map[string]string{}
+				for _, conn := range res.Connections {
+					// the pod names are <deployment>-<replicaset hash>-<pod hash>
+					parts := strings.Split(conn.FromPod, "-")
+					if len(parts) < 3 {
+						continue
+					}
+					got[conn.FromNamespace+"/"+strings.Join(parts[:len(parts)-2], "-")] = conn.ToFQDN
+				}
+				for caller, fqdn := range tt.want {
+					if got[caller] != fqdn {
+						return fmt.Errorf("connection %s -> %s not found in %v", caller, fqdn, got)
+					}
+				}
+
+				for _, ip := range tt.unresolved {
+					found := false
+					for _, u := range res.Unresolved {
+						found = found || u.IP == ip
+					}
+					if !found {
+						return fmt.Errorf("unresolved IP %s not found in %v", ip, res.Unresolved)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}